@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const backstageAPIVersion = "backstage.io/v1alpha1"
+
+// backstageEntity is a Backstage catalog entity, see
+// https://backstage.io/docs/features/software-catalog/descriptor-format
+type backstageEntity struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   backstageMetadata `yaml:"metadata"`
+	Spec       backstageSpec     `yaml:"spec"`
+}
+
+type backstageMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Title       string            `yaml:"title,omitempty"`
+	Description string            `yaml:"description,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	Tags        []string          `yaml:"tags,omitempty"`
+	Links       []backstageLink   `yaml:"links,omitempty"`
+}
+
+type backstageLink struct {
+	URL   string `yaml:"url"`
+	Title string `yaml:"title,omitempty"`
+}
+
+type backstageSpec struct {
+	Type      string   `yaml:"type"`
+	Lifecycle string   `yaml:"lifecycle"`
+	Owner     string   `yaml:"owner"`
+	DependsOn []string `yaml:"dependsOn,omitempty"`
+}
+
+var backstageInvalidChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// backstageName turns a name such as "Billing Team" into a valid Backstage
+// entity name, billing-team.
+func backstageName(name string) string {
+	return strings.Trim(backstageInvalidChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "-"), "-")
+}
+
+// newBackstageEntity describes a service as a Component. Its namespace
+// becomes the Backstage namespace, its first owner the owning group and
+// its dependencies components or, for databases, queues and external
+// services, resources.
+func newBackstageEntity(a about) backstageEntity {
+	e := backstageEntity{
+		APIVersion: backstageAPIVersion,
+		Kind:       "Component",
+		Metadata: backstageMetadata{
+			Name:        a.Service.Name,
+			Namespace:   a.Service.Namespace,
+			Title:       a.Doc.Name,
+			Description: a.Doc.Description,
+			Annotations: map[string]string{"about-aggregator/about": fmt.Sprintf("/__/about/%v/%v", a.Service.Namespace, a.Service.Name)},
+			Tags:        a.Doc.Tags,
+		},
+		Spec: backstageSpec{Type: "service", Lifecycle: a.Doc.Lifecycle, Owner: "unknown"},
+	}
+	if e.Spec.Lifecycle == "" {
+		e.Spec.Lifecycle = "unknown"
+	}
+	if len(a.Doc.Owners) > 0 {
+		e.Spec.Owner = "group:default/" + backstageName(a.Doc.Owners[0].Name)
+	}
+	if a.Doc.Repository != "" {
+		e.Metadata.Annotations["backstage.io/source-location"] = "url:" + a.Doc.Repository
+	}
+	if a.Doc.OnCall != "" {
+		e.Metadata.Annotations["about-aggregator/on-call"] = a.Doc.OnCall
+	}
+	if a.Doc.Tier != 0 {
+		e.Metadata.Annotations["about-aggregator/tier"] = strconv.Itoa(a.Doc.Tier)
+	}
+	for _, l := range a.Doc.Links {
+		e.Metadata.Links = append(e.Metadata.Links, backstageLink{URL: l.URL, Title: l.Description})
+	}
+	if a.Doc.Runbook != "" {
+		e.Metadata.Links = append(e.Metadata.Links, backstageLink{URL: a.Doc.Runbook, Title: "Runbook"})
+	}
+	for _, d := range a.Doc.Dependencies {
+		kind := "resource"
+		if d.kind() == dependencyService {
+			kind = "component"
+		}
+		e.Spec.DependsOn = append(e.Spec.DependsOn, kind+":"+d.target(a.Service.Namespace).key())
+	}
+	return e
+}
+
+// backstageExporter converts abouts into Backstage entities, served as one
+// yaml document per service for Backstage to ingest as a url location and,
+// when dir is set, written to dir/<namespace>/<name>.yaml.
+type backstageExporter struct {
+	dir          string
+	mutex        sync.RWMutex //protects abouts, entities and lastModified
+	abouts       map[string]about
+	entities     map[string]backstageEntity
+	lastModified time.Time
+}
+
+func newBackstageExporter(dir string) (*backstageExporter, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("Could not create backstage dir %v: (%v)", dir, err)
+		}
+	}
+	return &backstageExporter{dir: dir, mutex: sync.RWMutex{}, abouts: make(map[string]about), entities: make(map[string]backstageEntity)}, nil
+}
+
+func (b *backstageExporter) handle(about about) (bool, error) {
+	e := newBackstageEntity(about)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	key := about.Service.key()
+	if existing, ok := b.abouts[key]; ok && existing.sameContent(about) {
+		return false, nil
+	}
+	if b.dir != "" {
+		out, err := yaml.Marshal(e)
+		if err != nil {
+			return false, fmt.Errorf("Could not yaml encode backstage entity of %v: (%v)", about.Service.key(), err)
+		}
+		// recorded only once written, so that the next fetch retries a failed write
+		if err := writeFileAtomic(b.path(about.Service), out); err != nil {
+			return false, err
+		}
+	}
+	b.abouts[key] = about
+	b.entities[key] = e
+	b.lastModified = time.Now()
+	return true, nil
+}
+
+func (b *backstageExporter) remove(service service) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.entities[service.key()]; !ok {
+		return false, nil
+	}
+	delete(b.abouts, service.key())
+	delete(b.entities, service.key())
+	b.lastModified = time.Now()
+	if b.dir == "" {
+		return true, nil
+	}
+	if err := os.Remove(b.path(service)); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("Could not delete backstage entity of %v: (%v)", service.key(), err)
+	}
+	return true, nil
+}
+
+// prime deletes the entities of dir whose services weren't found by the
+// first discovery run, as they went away while the aggregator was down.
+func (b *backstageExporter) prime(services []service) error {
+	if b.dir == "" {
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, s := range services {
+		known[s.key()] = true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	files, err := filepath.Glob(filepath.Join(b.dir, "*", "*.yaml"))
+	if err != nil {
+		return fmt.Errorf("Could not list backstage entities: (%v)", err)
+	}
+	for _, f := range files {
+		s := service{Name: strings.TrimSuffix(filepath.Base(f), ".yaml"), Namespace: filepath.Base(filepath.Dir(f))}
+		if known[s.key()] {
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			return fmt.Errorf("Could not delete backstage entity of %v: (%v)", s.key(), err)
+		}
+		// fails, as it should, unless the namespace has no entities left
+		os.Remove(filepath.Dir(f))
+	}
+	return nil
+}
+
+func (b *backstageExporter) path(s service) string {
+	return filepath.Join(b.dir, s.Namespace, s.Name+".yaml")
+}
+
+// handleHTTP serves every entity as a multi-document yaml file.
+func (b *backstageExporter) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mutex.RLock()
+	keys := []string{}
+	for k := range b.entities {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out bytes.Buffer
+	for _, k := range keys {
+		doc, err := yaml.Marshal(b.entities[k])
+		if err != nil {
+			b.mutex.RUnlock()
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error during yaml encoding"))
+			return
+		}
+		out.WriteString("---\n")
+		out.Write(doc)
+	}
+	lastModified := b.lastModified
+	b.mutex.RUnlock()
+	w.Header().Set("Content-Type", "application/yaml")
+	serveContent(w, r, out.Bytes(), lastModified)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackstageEntity(t *testing.T) {
+	assert := assert.New(t)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{
+		Name:        "Reference data",
+		Description: "Tariffs and products",
+		Owners:      []owner{{Name: "Billing Team", Slack: "#billing"}, {Name: "Platform"}},
+		Links:       []link{{URL: "https://wiki/refdata", Description: "Wiki"}},
+		Dependencies: []dependency{
+			{Name: "uw-service-products"},
+			{Name: "uw-service-crm", Namespace: "crm"},
+			{Name: "billing-db", Type: dependencyDatabase},
+		},
+		Tags:       []string{"golang"},
+		Lifecycle:  lifecycleProduction,
+		Tier:       1,
+		Runbook:    "https://wiki/refdata/runbook",
+		OnCall:     "billing-primary",
+		Repository: "https://github.com/utilitywarehouse/uw-service-refdata",
+	}}
+
+	assert.Equal(backstageEntity{
+		APIVersion: "backstage.io/v1alpha1",
+		Kind:       "Component",
+		Metadata: backstageMetadata{
+			Name:        "uw-service-refdata",
+			Namespace:   "billing",
+			Title:       "Reference data",
+			Description: "Tariffs and products",
+			Annotations: map[string]string{
+				"about-aggregator/about":       "/__/about/billing/uw-service-refdata",
+				"about-aggregator/on-call":     "billing-primary",
+				"about-aggregator/tier":        "1",
+				"backstage.io/source-location": "url:https://github.com/utilitywarehouse/uw-service-refdata",
+			},
+			Tags:  []string{"golang"},
+			Links: []backstageLink{{URL: "https://wiki/refdata", Title: "Wiki"}, {URL: "https://wiki/refdata/runbook", Title: "Runbook"}},
+		},
+		Spec: backstageSpec{
+			Type:      "service",
+			Lifecycle: "production",
+			Owner:     "group:default/billing-team",
+			DependsOn: []string{"component:billing/uw-service-products", "component:crm/uw-service-crm", "resource:billing/billing-db"},
+		},
+	}, newBackstageEntity(refdata))
+
+	minimal := newBackstageEntity(about{Service: service{Name: "uw-service-crm", Namespace: "crm"}})
+	assert.Equal("unknown", minimal.Spec.Owner)
+	assert.Equal("unknown", minimal.Spec.Lifecycle)
+	assert.NotContains(minimal.Metadata.Annotations, "about-aggregator/tier")
+}
+
+func TestBackstageName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("billing-team", backstageName(" Billing Team "))
+	assert.Equal("crm-partners", backstageName("CRM & Partners!"))
+}
+
+func TestBackstageExporterHandler(t *testing.T) {
+	assert := assert.New(t)
+	b, _ := newBackstageExporter("")
+	b.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing"}}}})
+	b.handle(about{Service: service{Name: "uw-service-crm", Namespace: "crm"}})
+	b.handle(about{Service: service{Name: "uw-service-old", Namespace: "crm"}})
+	b.remove(service{Name: "uw-service-old", Namespace: "crm"})
+
+	rec := httptest.NewRecorder()
+	b.handleHTTP(rec, newRequest("GET", "/__/backstage", "", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("application/yaml", rec.Header().Get("Content-Type"))
+	assert.Equal(`---
+apiVersion: backstage.io/v1alpha1
+kind: Component
+metadata:
+  name: uw-service-refdata
+  namespace: billing
+  annotations:
+    about-aggregator/about: /__/about/billing/uw-service-refdata
+spec:
+  type: service
+  lifecycle: unknown
+  owner: group:default/billing
+---
+apiVersion: backstage.io/v1alpha1
+kind: Component
+metadata:
+  name: uw-service-crm
+  namespace: crm
+  annotations:
+    about-aggregator/about: /__/about/crm/uw-service-crm
+spec:
+  type: service
+  lifecycle: unknown
+  owner: unknown
+`, rec.Body.String())
+}
+
+func TestBackstageExporterWritesDir(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	b, err := newBackstageExporter(dir)
+	assert.NoError(err)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+
+	assert.Equal("published", outcome(b.handle(refdata)))
+	content, err := ioutil.ReadFile(filepath.Join(dir, "billing", "uw-service-refdata.yaml"))
+	assert.NoError(err)
+	assert.Contains(string(content), "kind: Component\n")
+	lastModified := b.lastModified
+	refdata.Fetched = time.Now()
+	assert.Equal("nothing published", outcome(b.handle(refdata)))
+	assert.Equal(lastModified, b.lastModified)
+
+	assert.Equal("published", outcome(b.remove(refdata.Service)))
+	_, err = os.Stat(filepath.Join(dir, "billing", "uw-service-refdata.yaml"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestBackstageExporterRetriesFailedWrites(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	b, _ := newBackstageExporter(dir)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+	// a file in place of the namespace dir makes the write fail
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "billing"), nil, 0644))
+
+	published, err := b.handle(refdata)
+	assert.False(published)
+	assert.Error(err)
+	assert.Empty(b.entities)
+
+	assert.NoError(os.Remove(filepath.Join(dir, "billing")))
+	assert.Equal("published", outcome(b.handle(refdata)))
+	_, err = os.Stat(filepath.Join(dir, "billing", "uw-service-refdata.yaml"))
+	assert.NoError(err)
+}
+
+func TestBackstageExporterPrunesServicesGoneWhileDown(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	b, _ := newBackstageExporter(dir)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+	invoices := about{Service: service{Name: "uw-service-invoices", Namespace: "billing"}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+	for _, a := range []about{refdata, invoices, crm} {
+		b.handle(a)
+	}
+
+	b, _ = newBackstageExporter(dir)
+	assert.NoError(b.prime([]service{refdata.Service}))
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*.yaml"))
+	assert.Equal([]string{filepath.Join(dir, "billing", "uw-service-refdata.yaml")}, files)
+	_, err := os.Stat(filepath.Join(dir, "crm"))
+	assert.True(os.IsNotExist(err))
+}
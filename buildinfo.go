@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// semver matches semantic versions, optionally prefixed with a v.
+var semver = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// builtAt returns when the build was made, if the about says so.
+func (b buildInfo) builtAt() (time.Time, bool) {
+	if b.BuildTime == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, b.BuildTime)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// age returns how old the build is at now.
+func (b buildInfo) age(now time.Time) (time.Duration, bool) {
+	t, ok := b.builtAt()
+	if !ok {
+		return 0, false
+	}
+	return now.Sub(t), true
+}
+
+func (b buildInfo) validate() []problem {
+	problems := []problem{}
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, problem{Kind: problemInvalidField, Message: fmt.Sprintf(format, args...)})
+	}
+	if b.Version != "" && !semver.MatchString(b.Version) {
+		invalid("build-info version must be a semantic version, was %q", b.Version)
+	}
+	if _, ok := b.builtAt(); b.BuildTime != "" && !ok {
+		invalid("build-info build-time must be an RFC 3339 timestamp, was %q", b.BuildTime)
+	}
+	if b.CIJob != "" && !isURL(b.CIJob) {
+		invalid("build-info ci-job must be an http(s) url, was %q", b.CIJob)
+	}
+	return problems
+}
+
+// humanizeDuration describes d with its largest unit, e.g. "3 days".
+func humanizeDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	default:
+		return plural(int(d/(365*24*time.Hour)), "year")
+	}
+}
+
+// buildAge describes how old a build is now, or returns an empty string if
+// its build time isn't known.
+func buildAge(b buildInfo) string {
+	age, ok := b.age(time.Now())
+	if !ok {
+		return ""
+	}
+	return humanizeDuration(age)
+}
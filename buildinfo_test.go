@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInfoAge(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2017, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	age, ok := buildInfo{BuildTime: "2017-03-07T12:00:00Z"}.age(now)
+	assert.True(ok)
+	assert.Equal(72*time.Hour, age)
+
+	_, ok = buildInfo{}.age(now)
+	assert.False(ok)
+	_, ok = buildInfo{BuildTime: "yesterday"}.age(now)
+	assert.False(ok)
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Second:      "less than a minute",
+		time.Minute:           "1 minute",
+		90 * time.Minute:      "1 hour",
+		50 * time.Hour:        "2 days",
+		65 * 24 * time.Hour:   "2 months",
+		800 * 24 * time.Hour:  "2 years",
+		364 * 24 * time.Hour:  "12 months",
+		29 * 24 * time.Hour:   "29 days",
+		23 * time.Hour:        "23 hours",
+		59 * time.Minute:      "59 minutes",
+		365 * 24 * time.Hour:  "1 year",
+		1000 * 24 * time.Hour: "2 years",
+	}
+	for d, expected := range tests {
+		assert.Equal(t, expected, humanizeDuration(d), d.String())
+	}
+}
+
+func TestBuildInfoValidate(t *testing.T) {
+	assert := assert.New(t)
+	assert.Empty(buildInfo{Revision: "abc", Version: "v1.2.3-rc.1+build.5", BuildTime: "2017-03-07T12:00:00+01:00", CIJob: "https://circleci.com/gh/utilitywarehouse/uw-service-refdata/42"}.validate())
+	assert.Equal([]problem{
+		{Kind: problemInvalidField, Message: "build-info version must be a semantic version, was \"1.2\""},
+		{Kind: problemInvalidField, Message: "build-info build-time must be an RFC 3339 timestamp, was \"07/03/2017\""},
+		{Kind: problemInvalidField, Message: "build-info ci-job must be an http(s) url, was \"circleci/42\""},
+	}, buildInfo{Version: "1.2", BuildTime: "07/03/2017", CIJob: "circleci/42"}.validate())
+}
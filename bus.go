@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// busEventSchemaVersion is bumped on incompatible changes of busEvent.
+const busEventSchemaVersion = 1
+
+const busKeyHeader = "About-Key"
+
+// busEvent is published for every change of the catalogue. Key identifies
+// the service, so consumers can keep a projection by key; Sequence orders
+// the events of one run of the aggregator and ID is unique across runs, for
+// consumers to drop the duplicates at-least-once delivery can cause.
+type busEvent struct {
+	SchemaVersion int       `json:"schema-version"`
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	Key           string    `json:"key"`
+	Sequence      uint64    `json:"sequence"`
+	Time          time.Time `json:"time"`
+	// About is the about of the service, its last one for removals.
+	About about `json:"about"`
+}
+
+// busMessage is a busEvent ready to be published on Subject.
+type busMessage struct {
+	Subject string
+	Key     string
+	ID      string
+	Data    []byte
+}
+
+// busPublisher publishes a message, returning once the bus has stored it.
+type busPublisher interface {
+	publish(m busMessage) error
+}
+
+// busExporter publishes add, update and remove events to a message bus, on
+// subject.<namespace>.<name>. Events are queued and published in order by
+// one drain at a time; those that can't be published after retries stay
+// queued and are retried by the next drain. Changes only queue their event
+// while a drain runs or the bus is down. At most one event per service is
+// queued, a new event replacing the queued one, so the queue stays bounded
+// by the size of the catalogue.
+type busExporter struct {
+	publisher busPublisher
+	subject   string
+	retries   int
+	backoff   time.Duration
+	instance  string
+	mutex     sync.Mutex //protects abouts, lastSequence, queue, draining and down
+	abouts    map[string]about
+	queue     []busEvent
+	// lastSequence numbers the events, starting at 1
+	lastSequence uint64
+	draining     bool
+	// down is true while the last drain failed
+	down bool
+}
+
+func newBusExporter(publisher busPublisher, subject string, retries int, backoff time.Duration) (*busExporter, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("subject is required")
+	}
+	if retries < 0 {
+		return nil, fmt.Errorf("Bus retries can't be negative")
+	}
+	return &busExporter{
+		publisher: publisher,
+		subject:   subject,
+		retries:   retries,
+		backoff:   backoff,
+		instance:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		mutex:     sync.Mutex{},
+		abouts:    make(map[string]about)}, nil
+}
+
+func (b *busExporter) handle(about about) (bool, error) {
+	b.mutex.Lock()
+	key := about.Service.key()
+	existing, ok := b.abouts[key]
+	if ok && existing.sameContent(about) {
+		b.mutex.Unlock()
+		return false, nil
+	}
+	b.abouts[key] = about
+	eventType := eventAdded
+	if ok {
+		eventType = eventUpdated
+	}
+	return b.publish(eventType, about)
+}
+
+func (b *busExporter) remove(service service) (bool, error) {
+	b.mutex.Lock()
+	existing, ok := b.abouts[service.key()]
+	if !ok {
+		b.mutex.Unlock()
+		return false, nil
+	}
+	delete(b.abouts, service.key())
+	return b.publish(eventRemoved, existing)
+}
+
+// publish queues a new event and drains the queue, unless a drain already
+// runs or the bus is down, leaving the event to those. Callers must hold the
+// mutex, which is released.
+func (b *busExporter) publish(eventType string, about about) (bool, error) {
+	b.enqueue(eventType, about)
+	if b.draining || b.down {
+		b.mutex.Unlock()
+		return false, nil
+	}
+	b.draining = true
+	b.mutex.Unlock()
+	return b.drainQueue()
+}
+
+// enqueue numbers a new event and queues it at the end, in place of the
+// queued event of the same service, if any. That event was never published,
+// so an add followed by an update stays an add and an add followed by a
+// remove leaves nothing to publish. The event being published by a drain is
+// left alone. Callers must hold the mutex.
+func (b *busExporter) enqueue(eventType string, about about) {
+	key := about.Service.key()
+	start := 0
+	if b.draining {
+		start = 1
+	}
+	for i := start; i < len(b.queue); i++ {
+		if b.queue[i].Key != key {
+			continue
+		}
+		queued := b.queue[i].Type
+		b.queue = append(b.queue[:i:i], b.queue[i+1:]...)
+		if queued == eventAdded && eventType == eventRemoved {
+			return
+		}
+		if queued == eventAdded {
+			eventType = eventAdded
+		}
+		break
+	}
+	b.lastSequence++
+	b.queue = append(b.queue, busEvent{
+		SchemaVersion: busEventSchemaVersion,
+		ID:            fmt.Sprintf("%v-%d", b.instance, b.lastSequence),
+		Type:          eventType,
+		Key:           about.Service.key(),
+		Sequence:      b.lastSequence,
+		Time:          time.Now(),
+		About:         about,
+	})
+}
+
+// drain publishes the queued events in order and stops at the first one
+// that can't be published. It returns at once if a drain already runs.
+func (b *busExporter) drain() (bool, error) {
+	b.mutex.Lock()
+	if b.draining {
+		b.mutex.Unlock()
+		return false, nil
+	}
+	b.draining = true
+	b.mutex.Unlock()
+	return b.drainQueue()
+}
+
+// drainQueue publishes the queued events, the caller having set draining,
+// and reports whether it published any.
+func (b *busExporter) drainQueue() (bool, error) {
+	for sent := false; ; sent = true {
+		b.mutex.Lock()
+		if len(b.queue) == 0 {
+			b.draining = false
+			b.down = false
+			b.mutex.Unlock()
+			return sent, nil
+		}
+		ev := b.queue[0]
+		b.mutex.Unlock()
+		err := b.send(ev)
+		b.mutex.Lock()
+		if err != nil {
+			b.draining = false
+			b.down = true
+			b.mutex.Unlock()
+			return false, err
+		}
+		b.queue = b.queue[1:]
+		b.mutex.Unlock()
+	}
+}
+
+// send publishes ev, retrying with exponential backoff.
+func (b *busExporter) send(ev busEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("Could not json encode bus event %v: (%v)", ev.ID, err)
+	}
+	m := busMessage{
+		Subject: fmt.Sprintf("%v.%v.%v", b.subject, ev.About.Service.Namespace, ev.About.Service.Name),
+		Key:     ev.Key,
+		ID:      ev.ID,
+		Data:    data,
+	}
+	for attempt := 0; attempt <= b.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(b.backoff * time.Duration(1<<uint(attempt-1)))
+		}
+		if err = b.publisher.publish(m); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Could not publish bus event %v of %v: (%v)", ev.ID, ev.Key, err)
+}
+
+// queueLength returns how many events wait to be published.
+func (b *busExporter) queueLength() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.queue)
+}
+
+// natsPublisher publishes to a NATS JetStream stream, which acknowledges
+// each message once stored and drops messages with an ID it has seen
+// within its duplicate window.
+type natsPublisher struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+// newNATSPublisher connects to url and creates the stream, capturing
+// subject.>, if it doesn't exist.
+func newNATSPublisher(url string, stream string, subject string, timeout time.Duration) (*natsPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("uw-service-about-aggregator"), nats.Timeout(timeout))
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to nats %v: (%v)", url, err)
+	}
+	js, err := conn.JetStream(nats.MaxWait(timeout))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not use nats jetstream: (%v)", err)
+	}
+	if _, err := js.StreamInfo(stream); err == nats.ErrStreamNotFound {
+		_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject + ".>"}})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Could not create nats stream %v: (%v)", stream, err)
+		}
+	} else if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not get nats stream %v: (%v)", stream, err)
+	}
+	return &natsPublisher{conn: conn, js: js}, nil
+}
+
+func (n *natsPublisher) publish(m busMessage) error {
+	msg := nats.NewMsg(m.Subject)
+	msg.Data = m.Data
+	msg.Header.Set(busKeyHeader, m.Key)
+	msg.Header.Set(nats.MsgIdHdr, m.ID)
+	_, err := n.js.PublishMsg(msg)
+	return err
+}
+
+func (n *natsPublisher) close() {
+	n.conn.Close()
+}
+
+// memoryBus is an in-memory stand-in for a message bus, for tests and local
+// development. Like JetStream it drops messages with an ID it has seen, and
+// it can be made to fail the next publishes.
+type memoryBus struct {
+	mutex    sync.Mutex
+	messages []busMessage
+	seen     map[string]bool
+	// failures is how many of the next publishes fail.
+	failures int
+}
+
+func newMemoryBus() *memoryBus {
+	return &memoryBus{mutex: sync.Mutex{}, seen: make(map[string]bool)}
+}
+
+func (m *memoryBus) publish(msg busMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return fmt.Errorf("memory bus unavailable")
+	}
+	if m.seen[msg.ID] {
+		return nil
+	}
+	m.seen[msg.ID] = true
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *memoryBus) fail(n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.failures = n
+}
+
+// received returns the events published so far.
+func (m *memoryBus) received() []busEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	events := []busEvent{}
+	for _, msg := range m.messages {
+		var ev busEvent
+		json.Unmarshal(msg.Data, &ev)
+		events = append(events, ev)
+	}
+	return events
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusExporterPublishesEvents(t *testing.T) {
+	assert := assert.New(t)
+	bus := newMemoryBus()
+	b, _ := newBusExporter(bus, "about.services", 0, 0)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "Reference data"}}
+	updated := about{Service: refdata.Service, Doc: doc{Name: "Reference data", Description: "Tariffs"}}
+
+	assert.Equal("published", outcome(b.handle(refdata)))
+	assert.Equal("nothing published", outcome(b.handle(refdata)))
+	assert.Equal("published", outcome(b.handle(updated)))
+	assert.Equal("published", outcome(b.remove(refdata.Service)))
+	assert.Equal("nothing published", outcome(b.remove(service{Name: "unknown", Namespace: "crm"})))
+
+	events := bus.received()
+	assert.Len(events, 3)
+	for i, ev := range events {
+		assert.Equal(1, ev.SchemaVersion)
+		assert.Equal("billing/uw-service-refdata", ev.Key)
+		assert.Equal(uint64(i+1), ev.Sequence)
+		assert.Equal(bus.messages[i].ID, ev.ID)
+		assert.Equal("billing/uw-service-refdata", bus.messages[i].Key)
+		assert.Equal("about.services.billing.uw-service-refdata", bus.messages[i].Subject)
+	}
+	assert.Equal([]string{eventAdded, eventUpdated, eventRemoved}, []string{events[0].Type, events[1].Type, events[2].Type})
+	assert.Equal("Reference data", events[0].About.Doc.Name)
+	assert.Equal("Tariffs", events[1].About.Doc.Description)
+	assert.Equal("Tariffs", events[2].About.Doc.Description)
+}
+
+func TestBusEventSchema(t *testing.T) {
+	assert := assert.New(t)
+	bus := newMemoryBus()
+	b, _ := newBusExporter(bus, "about.services", 0, 0)
+	b.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})
+
+	var fields map[string]json.RawMessage
+	assert.NoError(json.Unmarshal(bus.messages[0].Data, &fields))
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	assert.ElementsMatch([]string{"schema-version", "id", "type", "key", "sequence", "time", "about"}, keys)
+}
+
+func TestBusExporterRetries(t *testing.T) {
+	assert := assert.New(t)
+	bus := newMemoryBus()
+	b, _ := newBusExporter(bus, "about.services", 2, 0)
+
+	bus.fail(2)
+	assert.Equal("published", outcome(b.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})))
+	assert.Len(bus.received(), 1)
+}
+
+func TestBusExporterKeepsUnpublishedEventsQueued(t *testing.T) {
+	assert := assert.New(t)
+	bus := newMemoryBus()
+	b, _ := newBusExporter(bus, "about.services", 1, 0)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+
+	bus.fail(2)
+	assert.Equal("Could not publish bus event "+b.instance+"-1 of billing/uw-service-refdata: (memory bus unavailable)", outcome(b.handle(refdata)))
+	assert.Empty(bus.received())
+	assert.Equal(1, b.queueLength())
+
+	// while the bus is down changes are left to the next drain
+	assert.Equal("nothing published", outcome(b.handle(crm)))
+	assert.Equal(2, b.queueLength())
+
+	assert.Equal("published", outcome(b.drain()))
+	events := bus.received()
+	assert.Len(events, 2)
+	assert.Equal("billing/uw-service-refdata", events[0].Key)
+	assert.Equal("crm/uw-service-crm", events[1].Key)
+	assert.Empty(b.queue)
+}
+
+// blockingBus holds the first publish until released.
+type blockingBus struct {
+	*memoryBus
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingBus) publish(m busMessage) error {
+	b.once.Do(func() {
+		close(b.started)
+		<-b.release
+	})
+	return b.memoryBus.publish(m)
+}
+
+func TestBusExporterOnlyQueuesWhileDraining(t *testing.T) {
+	assert := assert.New(t)
+	bus := &blockingBus{memoryBus: newMemoryBus(), started: make(chan struct{}), release: make(chan struct{})}
+	b, _ := newBusExporter(bus, "about.services", 0, 0)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+
+	done := make(chan string)
+	go func() { done <- outcome(b.handle(refdata)) }()
+	<-bus.started
+	assert.Equal("nothing published", outcome(b.handle(crm)))
+	assert.Equal("nothing published", outcome(b.drain()))
+	assert.Equal(2, b.queueLength())
+
+	close(bus.release)
+	assert.Equal("published", <-done)
+	events := bus.received()
+	assert.Len(events, 2)
+	assert.Equal("billing/uw-service-refdata", events[0].Key)
+	assert.Equal("crm/uw-service-crm", events[1].Key)
+	assert.Empty(b.queue)
+}
+
+func TestBusExporterCoalescesQueuedEvents(t *testing.T) {
+	assert := assert.New(t)
+	bus := newMemoryBus()
+	b, _ := newBusExporter(bus, "about.services", 0, 0)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+	invoices := about{Service: service{Name: "uw-service-invoices", Namespace: "billing"}}
+	b.handle(crm)
+
+	bus.fail(1)
+	b.handle(refdata)
+	b.remove(crm.Service)
+	b.handle(invoices)
+	refdata.Doc.Description = "Reference data"
+	b.handle(refdata)
+	b.remove(invoices.Service)
+	assert.Equal(2, b.queueLength())
+
+	assert.Equal("published", outcome(b.drain()))
+	events := bus.received()
+	assert.Len(events, 3)
+	assert.Equal([]string{eventAdded, eventRemoved, eventAdded}, []string{events[0].Type, events[1].Type, events[2].Type})
+	assert.Equal("crm/uw-service-crm", events[1].Key)
+	assert.Equal("Reference data", events[2].About.Doc.Description)
+}
+
+func TestNewBusExporterValidates(t *testing.T) {
+	assert := assert.New(t)
+	_, err := newBusExporter(newMemoryBus(), "", 0, 0)
+	assert.EqualError(err, "subject is required")
+	_, err = newBusExporter(newMemoryBus(), "about.services", -1, 0)
+	assert.EqualError(err, "Bus retries can't be negative")
+}
+
+func TestMemoryBusDropsDuplicates(t *testing.T) {
+	assert := assert.New(t)
+	bus := newMemoryBus()
+	m := busMessage{Subject: "about.services.billing.uw-service-refdata", Key: "billing/uw-service-refdata", ID: "1", Data: []byte("{}")}
+
+	assert.NoError(bus.publish(m))
+	assert.NoError(bus.publish(m))
+	assert.Len(bus.messages, 1)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"sync"
+	"time"
 
 	"k8s.io/client-go/kubernetes"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -14,15 +16,23 @@ import (
 type serviceDiscovery struct {
 	client kubernetesClient
 	label  string
-	res    chan<- service
+	// probe also sends the services without the label, for the fetcher to
+	// try their /__/about.
+	probe   bool
+	res     chan<- service
+	removed chan<- service
+	// runs receives the services found by each complete run.
+	runs   chan<- []service
 	errors chan<- error
+	mutex  sync.Mutex //protects known
+	known  map[string]service
 }
 
 type kubernetesClient interface {
 	Core() v1core.CoreV1Interface
 }
 
-func newServiceDiscovery(host string, port string, tokenPath string, certPath string, label string, res chan<- service, errors chan<- error) (*serviceDiscovery, error) {
+func newServiceDiscovery(host string, port string, tokenPath string, certPath string, label string, probe bool, res chan<- service, removed chan<- service, runs chan<- []service, errors chan<- error) (*serviceDiscovery, error) {
 
 	config, err := clusterConfig(host, port, tokenPath, certPath)
 	if err != nil {
@@ -32,7 +42,7 @@ func newServiceDiscovery(host string, port string, tokenPath string, certPath st
 	if err != nil {
 		return &serviceDiscovery{}, err
 	}
-	return &serviceDiscovery{client: clientset, label: label, res: res, errors: errors}, nil
+	return &serviceDiscovery{client: clientset, label: label, probe: probe, res: res, removed: removed, runs: runs, errors: errors}, nil
 }
 
 func clusterConfig(host string, port string, tokenPath string, certPath string) (*rest.Config, error) {
@@ -51,36 +61,93 @@ func clusterConfig(host string, port string, tokenPath string, certPath string)
 }
 
 func (d *serviceDiscovery) getServices() {
+	start := time.Now()
+	defer func() { discoveryDuration.Observe(time.Since(start).Seconds()) }()
 	namespaces, err := d.client.Core().Namespaces().List(v1.ListOptions{})
 	if err != nil {
-		select {
-		case d.errors <- fmt.Errorf("Could not get namespaces via kubernetes api: (%v)", err):
-		default:
-		}
+		discoveryRuns.WithLabelValues(discoveryRunError).Inc()
+		reportError(d.errors, fmt.Errorf("Could not get namespaces via kubernetes api: (%v)", err))
 		return
 	}
+	found := make(map[string]service)
 	for _, n := range namespaces.Items {
 		services, err := d.client.Core().Services(n.Name).List(v1.ListOptions{LabelSelector: d.label})
 		if err != nil {
-			select {
-			case d.errors <- fmt.Errorf("Could not get services via kubernetes api: (%v)", err):
-			default:
-			}
+			discoveryRuns.WithLabelValues(discoveryRunError).Inc()
+			reportError(d.errors, fmt.Errorf("Could not get services via kubernetes api: (%v)", err))
 			return
 		}
 
 		for _, s := range services.Items {
-			d.res <- service{
+			svc := service{
 				Name:      s.Name,
 				Namespace: n.Name,
 				BaseURL:   fmt.Sprintf("http://%s.%s/", s.Name, n.Name),
 			}
+			found[svc.key()] = svc
+			d.res <- svc
+		}
+		if !d.probe {
+			continue
+		}
+		all, err := d.client.Core().Services(n.Name).List(v1.ListOptions{})
+		if err != nil {
+			discoveryRuns.WithLabelValues(discoveryRunError).Inc()
+			reportError(d.errors, fmt.Errorf("Could not get unlabelled services via kubernetes api: (%v)", err))
+			return
+		}
+		for _, s := range all.Items {
+			svc := service{
+				Name:       s.Name,
+				Namespace:  n.Name,
+				BaseURL:    fmt.Sprintf("http://%s.%s/", s.Name, n.Name),
+				Unlabelled: true,
+			}
+			if _, ok := found[svc.key()]; ok {
+				continue
+			}
+			found[svc.key()] = svc
+			d.res <- svc
 		}
 	}
+	discoveryRuns.WithLabelValues(discoveryRunSuccess).Inc()
+	discoveredServices.Reset()
+	for _, s := range found {
+		discoveredServices.WithLabelValues(s.Namespace, discoveredBy(s)).Inc()
+	}
+	d.forget(found)
+	if d.runs != nil {
+		list := []service{}
+		for _, s := range found {
+			list = append(list, s)
+		}
+		d.runs <- list
+	}
+}
+
+// forget reports services seen by the previous run that are missing from
+// found, and remembers found for the next run. It is only called after a
+// complete run so that api errors don't look like removals.
+func (d *serviceDiscovery) forget(found map[string]service) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key, s := range d.known {
+		if _, ok := found[key]; !ok && d.removed != nil {
+			d.removed <- s
+		}
+	}
+	d.known = found
 }
 
 type service struct {
 	Name      string
 	Namespace string
 	BaseURL   string
+	// Unlabelled services were found by probing rather than by label.
+	Unlabelled bool `json:",omitempty"`
+}
+
+// key uniquely identifies a service across namespaces.
+func (s service) key() string {
+	return s.Namespace + "/" + s.Name
 }
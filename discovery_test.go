@@ -53,14 +53,84 @@ func TestDiscoveryErrorAddedToChannel(t *testing.T) {
 	}
 }
 
+func TestDiscoveryRemovedServicesAddedToChannel(t *testing.T) {
+	errors := make(chan error, 10)
+	services := make(chan service, 10)
+	removed := make(chan service, 10)
+	oldService := service{Name: "oldService", Namespace: "billing", BaseURL: "http://oldService.billing/"}
+	runs := make(chan []service, 1)
+	d := serviceDiscovery{client: &mockK8Client{}, label: "about=true", res: services, removed: removed, runs: runs, errors: errors, known: map[string]service{oldService.key(): oldService}}
+
+	d.getServices()
+	close(services)
+	close(removed)
+	close(errors)
+	close(runs)
+
+	assert.Equal(t, []service{oldService}, func() []service {
+		r := []service{}
+		for s := range removed {
+			r = append(r, s)
+		}
+		return r
+	}())
+	assert.Equal(t, map[string]service{"billing/someService": {Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/"}}, d.known)
+	assert.Equal(t, []service{{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/"}}, <-runs)
+}
+
+func TestDiscoveryNothingRemovedOnError(t *testing.T) {
+	errors := make(chan error, 10)
+	services := make(chan service, 10)
+	removed := make(chan service, 10)
+	oldService := service{Name: "oldService", Namespace: "billing", BaseURL: "http://oldService.billing/"}
+	runs := make(chan []service, 1)
+	d := serviceDiscovery{client: &mockK8Client{}, label: "", res: services, removed: removed, runs: runs, errors: errors, known: map[string]service{oldService.key(): oldService}}
+
+	d.getServices()
+	close(removed)
+	close(runs)
+
+	for range removed {
+		t.Errorf("Should not remove services when the kubernetes api fails")
+	}
+	for range runs {
+		t.Errorf("Should not report a run when the kubernetes api fails")
+	}
+}
+
+func TestDiscoveryProbesUnlabelledServices(t *testing.T) {
+	errors := make(chan error, 10)
+	services := make(chan service, 10)
+	all := &v1.ServiceList{Items: []v1.Service{{ObjectMeta: v1.ObjectMeta{Name: "someService"}}, {ObjectMeta: v1.ObjectMeta{Name: "otherService"}}}}
+	d := serviceDiscovery{client: &mockK8Client{all: all}, label: "about=true", probe: true, res: services, errors: errors}
+
+	d.getServices()
+	close(services)
+	close(errors)
+
+	for err := range errors {
+		t.Errorf("Should not get an error: %v", err)
+	}
+	found := []service{}
+	for s := range services {
+		found = append(found, s)
+	}
+	assert.Equal(t, []service{
+		{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/"},
+		{Name: "otherService", Namespace: "billing", BaseURL: "http://otherService.billing/", Unlabelled: true},
+	}, found)
+}
+
 type mockK8Client struct {
+	all *v1.ServiceList
 }
 
 func (m *mockK8Client) Core() v1core.CoreV1Interface {
-	return &mockCoreClient{}
+	return &mockCoreClient{all: m.all}
 }
 
 type mockCoreClient struct {
+	all *v1.ServiceList
 }
 
 func (c *mockCoreClient) Namespaces() v1core.NamespaceInterface {
@@ -68,7 +138,7 @@ func (c *mockCoreClient) Namespaces() v1core.NamespaceInterface {
 }
 
 func (c *mockCoreClient) Services(namespace string) v1core.ServiceInterface {
-	return &mockServiceClient{services: &v1.ServiceList{Items: []v1.Service{{ObjectMeta: v1.ObjectMeta{Name: "someService"}}}}}
+	return &mockServiceClient{services: &v1.ServiceList{Items: []v1.Service{{ObjectMeta: v1.ObjectMeta{Name: "someService"}}}}, all: c.all}
 
 }
 
@@ -171,6 +241,7 @@ func (n *mockNamespaceClient) Finalize(item *v1.Namespace) (*v1.Namespace, error
 
 type mockServiceClient struct {
 	services *v1.ServiceList
+	all      *v1.ServiceList
 }
 
 func (c *mockServiceClient) List(opts v1.ListOptions) (*v1.ServiceList, error) {
@@ -178,6 +249,9 @@ func (c *mockServiceClient) List(opts v1.ListOptions) (*v1.ServiceList, error) {
 	if opts == expectedOpts {
 		return c.services, nil
 	}
+	if opts == (v1.ListOptions{}) && c.all != nil {
+		return c.all, nil
+	}
 	return &v1.ServiceList{}, fmt.Errorf("No service matching label")
 }
 
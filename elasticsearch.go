@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// elasticsearchMapping is the mapping of the index, so that owners and links
+// can be matched as a whole and tags, namespaces and names exactly.
+const elasticsearchMapping = `{
+  "mappings": {
+    "properties": {
+      "namespace":   {"type": "keyword"},
+      "name":        {"type": "keyword"},
+      "title":       {"type": "text"},
+      "description": {"type": "text"},
+      "owners": {
+        "type": "nested",
+        "properties": {
+          "name":  {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+          "slack": {"type": "keyword"}
+        }
+      },
+      "links": {
+        "type": "nested",
+        "properties": {
+          "url":         {"type": "keyword"},
+          "description": {"type": "text"}
+        }
+      },
+      "tags":       {"type": "keyword"},
+      "lifecycle":  {"type": "keyword"},
+      "tier":       {"type": "integer"},
+      "runbook":    {"type": "keyword"},
+      "on-call":    {"type": "keyword"},
+      "repository": {"type": "keyword"},
+      "version":    {"type": "keyword"},
+      "revision":   {"type": "keyword"},
+      "broken":     {"type": "text"},
+      "fetched":    {"type": "date"}
+    }
+  }
+}`
+
+// elasticsearchDocument is what is indexed for a service, with its id being
+// the key of the service.
+type elasticsearchDocument struct {
+	Namespace   string    `json:"namespace"`
+	Name        string    `json:"name"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Owners      []owner   `json:"owners,omitempty"`
+	Links       []link    `json:"links,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Lifecycle   string    `json:"lifecycle,omitempty"`
+	Tier        int       `json:"tier,omitempty"`
+	Runbook     string    `json:"runbook,omitempty"`
+	OnCall      string    `json:"on-call,omitempty"`
+	Repository  string    `json:"repository,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Revision    string    `json:"revision,omitempty"`
+	Broken      string    `json:"broken,omitempty"`
+	Fetched     time.Time `json:"fetched"`
+}
+
+func newElasticsearchDocument(a about) elasticsearchDocument {
+	return elasticsearchDocument{
+		Namespace:   a.Service.Namespace,
+		Name:        a.Service.Name,
+		Title:       a.Doc.Name,
+		Description: a.Doc.Description,
+		Owners:      a.Doc.Owners,
+		Links:       a.Doc.Links,
+		Tags:        a.Doc.Tags,
+		Lifecycle:   a.Doc.Lifecycle,
+		Tier:        a.Doc.Tier,
+		Runbook:     a.Doc.Runbook,
+		OnCall:      a.Doc.OnCall,
+		Repository:  a.Doc.Repository,
+		Version:     a.Doc.BuildInfo.Version,
+		Revision:    a.Doc.BuildInfo.Revision,
+		Broken:      a.Broken,
+		Fetched:     a.Fetched,
+	}
+}
+
+// elasticsearchExporter indexes each service into an Elasticsearch or
+// OpenSearch index. Changes are queued and sent through the bulk api by
+// flush, when batchSize of them are queued or periodically, at most
+// batchSize per request. Only the latest change of a service is sent, a nil
+// document meaning a delete. The index is created by the first flush, so
+// that an unavailable cluster doesn't stop the aggregator from starting.
+type elasticsearchExporter struct {
+	url       string
+	index     string
+	batchSize int
+	client    httpClient
+	mutex     sync.Mutex //protects abouts and pending
+	abouts    map[string]about
+	pending   map[string]*elasticsearchDocument
+	flushing  sync.Mutex //serialises bulk requests and protects indexed
+	// indexed is true once the index is known to exist
+	indexed bool
+}
+
+func newElasticsearchExporter(url string, index string, batchSize int, client httpClient) (*elasticsearchExporter, error) {
+	if url == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if index == "" {
+		return nil, fmt.Errorf("index is required")
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("Elasticsearch batch size must be at least 1")
+	}
+	return &elasticsearchExporter{
+		url:       strings.TrimSuffix(url, "/"),
+		index:     index,
+		batchSize: batchSize,
+		client:    client,
+		mutex:     sync.Mutex{},
+		abouts:    make(map[string]about),
+		pending:   make(map[string]*elasticsearchDocument)}, nil
+}
+
+// createIndex creates the index with its mapping unless it exists already.
+func (e *elasticsearchExporter) createIndex() error {
+	resp, err := e.do("HEAD", "/"+e.index, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Elasticsearch returned status %d checking index %v", resp.StatusCode, e.index)
+	}
+	resp, err = e.do("PUT", "/"+e.index, "application/json", []byte(elasticsearchMapping))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Could not create elasticsearch index %v: status %d (%v)", e.index, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (e *elasticsearchExporter) handle(about about) (bool, error) {
+	e.mutex.Lock()
+	key := about.Service.key()
+	existing, ok := e.abouts[key]
+	if ok && existing.sameContent(about) {
+		e.mutex.Unlock()
+		return false, nil
+	}
+	e.abouts[key] = about
+	d := newElasticsearchDocument(about)
+	e.pending[key] = &d
+	full := len(e.pending) >= e.batchSize
+	e.mutex.Unlock()
+	return e.flushIf(full)
+}
+
+func (e *elasticsearchExporter) remove(service service) (bool, error) {
+	e.mutex.Lock()
+	key := service.key()
+	if _, ok := e.abouts[key]; !ok {
+		e.mutex.Unlock()
+		return false, nil
+	}
+	delete(e.abouts, key)
+	e.pending[key] = nil
+	full := len(e.pending) >= e.batchSize
+	e.mutex.Unlock()
+	return e.flushIf(full)
+}
+
+// flushIf flushes when full, a batch being ready, and otherwise leaves the
+// changes queued.
+func (e *elasticsearchExporter) flushIf(full bool) (bool, error) {
+	if !full {
+		return false, nil
+	}
+	return e.flush()
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool                                     `json:"errors"`
+	Items  []map[string]elasticsearchBulkItemResult `json:"items"`
+}
+
+type elasticsearchBulkItemResult struct {
+	ID     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// flush sends the queued changes in bulk requests of at most batchSize,
+// creating the index first if needed. When a request fails its changes and
+// those not sent yet are queued again, unless a newer change came in
+// meanwhile; changes rejected by elasticsearch are dropped and reported.
+func (e *elasticsearchExporter) flush() (bool, error) {
+	e.flushing.Lock()
+	defer e.flushing.Unlock()
+	e.mutex.Lock()
+	pending := e.pending
+	e.pending = make(map[string]*elasticsearchDocument)
+	e.mutex.Unlock()
+	if len(pending) == 0 {
+		return false, nil
+	}
+	keys := []string{}
+	for k := range pending {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if !e.indexed {
+		if err := e.createIndex(); err != nil {
+			e.requeue(pending, keys)
+			return false, err
+		}
+		e.indexed = true
+	}
+
+	failed := []string{}
+	for start := 0; start < len(keys); start += e.batchSize {
+		end := start + e.batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		rejected, err := e.send(pending, keys[start:end])
+		if err != nil {
+			e.requeue(pending, keys[start:])
+			return false, err
+		}
+		failed = append(failed, rejected...)
+	}
+	if len(failed) > 0 {
+		return false, fmt.Errorf("Elasticsearch rejected %d changes: (%v)", len(failed), strings.Join(failed, ", "))
+	}
+	return true, nil
+}
+
+// send sends the changes of keys in one bulk request and returns those
+// elasticsearch rejected.
+func (e *elasticsearchExporter) send(pending map[string]*elasticsearchDocument, keys []string) ([]string, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, k := range keys {
+		meta := map[string]string{"_index": e.index, "_id": k}
+		if pending[k] == nil {
+			enc.Encode(map[string]interface{}{"delete": meta})
+			continue
+		}
+		enc.Encode(map[string]interface{}{"index": meta})
+		enc.Encode(pending[k])
+	}
+
+	results, err := e.bulk(body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	failed := []string{}
+	for _, item := range results.Items {
+		for action, result := range item {
+			if result.Status >= 200 && result.Status <= 299 {
+				continue
+			}
+			// deleting a document that was never indexed is fine
+			if action == "delete" && result.Status == http.StatusNotFound {
+				continue
+			}
+			failed = append(failed, fmt.Sprintf("%v %v: %v %v", action, result.ID, result.Error.Type, result.Error.Reason))
+		}
+	}
+	return failed, nil
+}
+
+// requeue queues the changes of keys again, unless a newer one came in.
+func (e *elasticsearchExporter) requeue(pending map[string]*elasticsearchDocument, keys []string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, k := range keys {
+		if _, ok := e.pending[k]; !ok {
+			e.pending[k] = pending[k]
+		}
+	}
+}
+
+func (e *elasticsearchExporter) bulk(body []byte) (elasticsearchBulkResponse, error) {
+	var results elasticsearchBulkResponse
+	resp, err := e.do("POST", "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return results, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return results, fmt.Errorf("Elasticsearch bulk request returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return results, fmt.Errorf("Could not decode elasticsearch bulk response: (%v)", err)
+	}
+	return results, nil
+}
+
+func (e *elasticsearchExporter) do(method string, path string, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create elasticsearch request: (%v)", err)
+	}
+	if contentType != "" {
+		req.Header.Add("Content-Type", contentType)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get response from elasticsearch: (%v)", err)
+	}
+	return resp, nil
+}
+
+// queueLength returns how many services have changes waiting to be sent.
+func (e *elasticsearchExporter) queueLength() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.pending)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeElasticsearch keeps the documents of one index, written through the
+// bulk api.
+type fakeElasticsearch struct {
+	mutex     sync.Mutex
+	mapping   string
+	documents map[string]elasticsearchDocument
+	bulks     int
+	status    int
+	reject    string
+	// down makes every request fail, as when the cluster is unavailable
+	down bool
+}
+
+func newFakeElasticsearch(t *testing.T) (*fakeElasticsearch, string) {
+	es := &fakeElasticsearch{documents: make(map[string]elasticsearchDocument)}
+	server := httptest.NewServer(es)
+	t.Cleanup(server.Close)
+	return es, server.URL
+}
+
+func (es *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if es.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch {
+	case r.Method == "HEAD" && r.URL.Path == "/services":
+		if es.mapping == "" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == "PUT" && r.URL.Path == "/services":
+		b, _ := ioutil.ReadAll(r.Body)
+		es.mapping = string(b)
+	case r.Method == "POST" && r.URL.Path == "/_bulk" && r.Header.Get("Content-Type") == "application/x-ndjson":
+		es.bulks++
+		if es.status != 0 {
+			w.WriteHeader(es.status)
+			return
+		}
+		json.NewEncoder(w).Encode(es.bulk(bufio.NewScanner(r.Body)))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (es *fakeElasticsearch) bulk(lines *bufio.Scanner) elasticsearchBulkResponse {
+	resp := elasticsearchBulkResponse{}
+	for lines.Scan() {
+		var action map[string]struct {
+			ID string `json:"_id"`
+		}
+		json.Unmarshal(lines.Bytes(), &action)
+		result := elasticsearchBulkItemResult{Status: http.StatusOK}
+		if meta, ok := action["index"]; ok {
+			lines.Scan()
+			var d elasticsearchDocument
+			json.Unmarshal(lines.Bytes(), &d)
+			result.ID = meta.ID
+			if meta.ID == es.reject {
+				result.Status = http.StatusBadRequest
+				result.Error.Type = "mapper_parsing_exception"
+				result.Error.Reason = "failed to parse"
+				resp.Errors = true
+			} else {
+				es.documents[meta.ID] = d
+			}
+			resp.Items = append(resp.Items, map[string]elasticsearchBulkItemResult{"index": result})
+			continue
+		}
+		meta := action["delete"]
+		result.ID = meta.ID
+		if _, ok := es.documents[meta.ID]; !ok {
+			result.Status = http.StatusNotFound
+		}
+		delete(es.documents, meta.ID)
+		resp.Items = append(resp.Items, map[string]elasticsearchBulkItemResult{"delete": result})
+	}
+	return resp
+}
+
+func keysOf(documents map[string]elasticsearchDocument) []string {
+	keys := []string{}
+	for k := range documents {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestElasticsearchExporterCreatesIndex(t *testing.T) {
+	assert := assert.New(t)
+	es, url := newFakeElasticsearch(t)
+	e, _ := newElasticsearchExporter(url, "services", 10, http.DefaultClient)
+
+	assert.NoError(e.createIndex())
+	assert.Equal(elasticsearchMapping, es.mapping)
+	es.mapping = "{}"
+	assert.NoError(e.createIndex())
+	assert.Equal("{}", es.mapping)
+}
+
+func TestElasticsearchExporterIndexesChanges(t *testing.T) {
+	assert := assert.New(t)
+	es, url := newFakeElasticsearch(t)
+	e, _ := newElasticsearchExporter(url, "services", 10, http.DefaultClient)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{
+		Name:      "Reference data",
+		Owners:    []owner{{Name: "Billing", Slack: "#billing"}},
+		Links:     []link{{URL: "https://wiki/refdata", Description: "Wiki"}},
+		Tags:      []string{"golang"},
+		BuildInfo: buildInfo{Revision: "abc", Version: "1.2.0"},
+	}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+
+	e.handle(refdata)
+	e.handle(crm)
+	assert.Equal("published", outcome(e.flush()))
+	assert.Equal(1, es.bulks)
+	assert.Equal(map[string]elasticsearchDocument{
+		"billing/uw-service-refdata": {
+			Namespace: "billing",
+			Name:      "uw-service-refdata",
+			Title:     "Reference data",
+			Owners:    []owner{{Name: "Billing", Slack: "#billing"}},
+			Links:     []link{{URL: "https://wiki/refdata", Description: "Wiki"}},
+			Tags:      []string{"golang"},
+			Version:   "1.2.0",
+			Revision:  "abc",
+		},
+		"crm/uw-service-crm": {Namespace: "crm", Name: "uw-service-crm"},
+	}, es.documents)
+
+	// unchanged services aren't sent again, removed ones are deleted
+	e.handle(refdata)
+	e.remove(crm.Service)
+	e.remove(service{Name: "unknown", Namespace: "crm"})
+	assert.Equal("published", outcome(e.flush()))
+	assert.Equal(2, es.bulks)
+	assert.Equal([]string{"billing/uw-service-refdata"}, keysOf(es.documents))
+
+	assert.Equal("nothing published", outcome(e.flush()))
+	assert.Equal(2, es.bulks)
+}
+
+func TestElasticsearchExporterFlushesFullBatches(t *testing.T) {
+	assert := assert.New(t)
+	es, url := newFakeElasticsearch(t)
+	e, _ := newElasticsearchExporter(url, "services", 2, http.DefaultClient)
+
+	for i := 0; i < 5; i++ {
+		published, err := e.handle(about{Service: service{Name: fmt.Sprintf("service-%d", i), Namespace: "billing"}})
+		assert.NoError(err)
+		assert.Equal(i%2 == 1, published, "only full batches are published")
+	}
+	assert.Equal(2, es.bulks)
+	assert.Len(es.documents, 4)
+	assert.Len(e.pending, 1)
+}
+
+func TestElasticsearchExporterFlushesInBatches(t *testing.T) {
+	assert := assert.New(t)
+	es, url := newFakeElasticsearch(t)
+	e, _ := newElasticsearchExporter(url, "services", 2, http.DefaultClient)
+	for i := 0; i < 5; i++ {
+		e.pending[fmt.Sprintf("billing/service-%d", i)] = &elasticsearchDocument{Namespace: "billing", Name: fmt.Sprintf("service-%d", i)}
+	}
+
+	assert.Equal("published", outcome(e.flush()))
+	assert.Equal(3, es.bulks)
+	assert.Len(es.documents, 5)
+	assert.Empty(e.pending)
+}
+
+func TestElasticsearchExporterCreatesIndexOnFirstFlush(t *testing.T) {
+	assert := assert.New(t)
+	es, url := newFakeElasticsearch(t)
+	e, _ := newElasticsearchExporter(url, "services", 10, http.DefaultClient)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+
+	es.down = true
+	e.handle(refdata)
+	assert.Equal("Elasticsearch returned status 503 checking index services", outcome(e.flush()))
+	assert.Equal(1, e.queueLength())
+
+	es.down = false
+	assert.Equal("published", outcome(e.flush()))
+	assert.Equal(elasticsearchMapping, es.mapping)
+	assert.Equal([]string{"billing/uw-service-refdata"}, keysOf(es.documents))
+}
+
+func TestElasticsearchExporterRequeuesFailedBulks(t *testing.T) {
+	assert := assert.New(t)
+	es, url := newFakeElasticsearch(t)
+	e, _ := newElasticsearchExporter(url, "services", 10, http.DefaultClient)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+
+	es.status = http.StatusServiceUnavailable
+	e.handle(refdata)
+	assert.Equal("Elasticsearch bulk request returned status 503", outcome(e.flush()))
+	assert.Empty(es.documents)
+
+	es.status = 0
+	assert.Equal("published", outcome(e.flush()))
+	assert.Equal([]string{"billing/uw-service-refdata"}, keysOf(es.documents))
+}
+
+func TestElasticsearchExporterReportsRejectedDocuments(t *testing.T) {
+	assert := assert.New(t)
+	es, url := newFakeElasticsearch(t)
+	e, _ := newElasticsearchExporter(url, "services", 10, http.DefaultClient)
+	es.reject = "billing/uw-service-refdata"
+
+	e.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})
+	e.handle(about{Service: service{Name: "uw-service-crm", Namespace: "crm"}})
+	published, err := e.flush()
+	assert.False(published)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "index billing/uw-service-refdata: mapper_parsing_exception failed to parse"))
+	assert.Equal([]string{"crm/uw-service-crm"}, keysOf(es.documents))
+	assert.Empty(e.pending)
+}
+
+func TestNewElasticsearchExporterValidates(t *testing.T) {
+	assert := assert.New(t)
+	_, err := newElasticsearchExporter("", "services", 10, http.DefaultClient)
+	assert.Error(err)
+	_, err = newElasticsearchExporter("http://localhost:9200", "", 10, http.DefaultClient)
+	assert.Error(err)
+	_, err = newElasticsearchExporter("http://localhost:9200", "services", 0, http.DefaultClient)
+	assert.Error(err)
+}
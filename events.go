@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	eventAdded   = "add"
+	eventUpdated = "update"
+	eventRemoved = "remove"
+	// eventReset tells clients to drop what they know, the add events
+	// following it being the whole catalogue.
+	eventReset = "reset"
+)
+
+const eventKeepAliveInterval = 30 * time.Second
+
+type event struct {
+	ID    uint64 `json:"id"`
+	Type  string `json:"type"`
+	About about  `json:"about"`
+}
+
+func newEventExporter(history int) *eventExporter {
+	return &eventExporter{
+		mutex:       sync.Mutex{},
+		abouts:      make(map[string]about),
+		history:     history,
+		subscribers: make(map[chan event]struct{}),
+	}
+}
+
+// eventExporter turns the abouts it is handed into add/update/remove events
+// and streams them to subscribers as server-sent events. The most recent
+// events are kept so that clients can resume with Last-Event-ID.
+type eventExporter struct {
+	mutex       sync.Mutex //protects abouts, events, lastID and subscribers
+	abouts      map[string]about
+	events      []event
+	history     int
+	lastID      uint64
+	subscribers map[chan event]struct{}
+}
+
+func (e *eventExporter) handle(about about) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	key := about.Service.key()
+	existing, ok := e.abouts[key]
+	if ok && existing.sameContent(about) {
+		return false, nil
+	}
+	e.abouts[key] = about
+	if ok {
+		e.publish(eventUpdated, about)
+	} else {
+		e.publish(eventAdded, about)
+	}
+	return true, nil
+}
+
+func (e *eventExporter) remove(service service) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	existing, ok := e.abouts[service.key()]
+	if !ok {
+		return false, nil
+	}
+	delete(e.abouts, service.key())
+	e.publish(eventRemoved, existing)
+	return true, nil
+}
+
+// publish records a new event and fans it out to subscribers. Subscribers
+// that can't keep up are disconnected and expected to resume with
+// Last-Event-ID. Callers must hold the mutex.
+func (e *eventExporter) publish(eventType string, about about) {
+	e.lastID++
+	ev := event{ID: e.lastID, Type: eventType, About: about}
+	e.events = append(e.events, ev)
+	if len(e.events) > e.history {
+		e.events = e.events[len(e.events)-e.history:]
+	}
+	for ch := range e.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a new subscriber and returns the retained events
+// published after lastID. When those aren't all retained, or lastID is from
+// before a restart, it returns a reset event followed by an add event for
+// every service instead.
+func (e *eventExporter) subscribe(lastID uint64) (chan event, []event) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	backlog := []event{}
+	if lastID > e.lastID || lastID < e.lastID-uint64(len(e.events)) {
+		backlog = e.snapshot()
+	} else {
+		for _, ev := range e.events {
+			if ev.ID > lastID {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	ch := make(chan event, 10)
+	e.subscribers[ch] = struct{}{}
+	return ch, backlog
+}
+
+// snapshot returns a reset event and an add event per service, sorted by
+// key, all with the current id for clients to resume from. Callers must
+// hold the mutex.
+func (e *eventExporter) snapshot() []event {
+	keys := []string{}
+	for k := range e.abouts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	events := []event{{ID: e.lastID, Type: eventReset}}
+	for _, k := range keys {
+		events = append(events, event{ID: e.lastID, Type: eventAdded, About: e.abouts[k]})
+	}
+	return events
+}
+
+func (e *eventExporter) unsubscribe(ch chan event) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if _, ok := e.subscribers[ch]; ok {
+		delete(e.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (e *eventExporter) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Streaming is not supported"))
+		return
+	}
+	lastID := e.currentID()
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		parsed, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Last-Event-ID must be a number"))
+			return
+		}
+		lastID = parsed
+	}
+	ch, backlog := e.subscribe(lastID)
+	defer e.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, ev := range backlog {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (e *eventExporter) currentID() uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.lastID
+}
+
+func writeEvent(w http.ResponseWriter, ev event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventExporterPublishesChanges(t *testing.T) {
+	assert := assert.New(t)
+	e := newEventExporter(10)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata"}}
+	updated := about{Service: refdata.Service, Doc: doc{Name: "uw-service-refdata", BuildInfo: buildInfo{Revision: "new"}}}
+
+	e.handle(refdata)
+	e.handle(refdata)
+	e.handle(updated)
+	e.remove(refdata.Service)
+	e.remove(refdata.Service)
+
+	assert.Equal([]event{
+		{ID: 1, Type: eventAdded, About: refdata},
+		{ID: 2, Type: eventUpdated, About: updated},
+		{ID: 3, Type: eventRemoved, About: updated},
+	}, e.events)
+}
+
+func TestEventExporterPublishesBrokenServices(t *testing.T) {
+	assert := assert.New(t)
+	e := newEventExporter(10)
+	broken := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Broken: "/__/about returned 404"}
+	stillBroken := about{Service: broken.Service, Broken: "/__/about is not json"}
+
+	e.handle(broken)
+	e.handle(stillBroken)
+
+	assert.Equal([]event{
+		{ID: 1, Type: eventAdded, About: broken},
+		{ID: 2, Type: eventUpdated, About: stillBroken},
+	}, e.events)
+}
+
+func TestEventExporterKeepsHistory(t *testing.T) {
+	assert := assert.New(t)
+	e := newEventExporter(2)
+	for _, name := range []string{"a", "b", "c"} {
+		e.handle(about{Service: service{Name: name, Namespace: "billing"}})
+	}
+
+	_, backlog := e.subscribe(1)
+	assert.Equal([]uint64{2, 3}, ids(backlog))
+	_, backlog = e.subscribe(2)
+	assert.Equal([]uint64{3}, ids(backlog))
+}
+
+func TestEventExporterResetsClientsThatCantResume(t *testing.T) {
+	assert := assert.New(t)
+	e := newEventExporter(2)
+	for _, name := range []string{"c", "a", "b"} {
+		e.handle(about{Service: service{Name: name, Namespace: "billing"}})
+	}
+	reset := []event{
+		{ID: 3, Type: eventReset},
+		{ID: 3, Type: eventAdded, About: about{Service: service{Name: "a", Namespace: "billing"}}},
+		{ID: 3, Type: eventAdded, About: about{Service: service{Name: "b", Namespace: "billing"}}},
+		{ID: 3, Type: eventAdded, About: about{Service: service{Name: "c", Namespace: "billing"}}},
+	}
+
+	// event 1 isn't retained anymore
+	_, backlog := e.subscribe(0)
+	assert.Equal(reset, backlog)
+	// the id is from before a restart
+	_, backlog = e.subscribe(42)
+	assert.Equal(reset, backlog)
+	_, backlog = e.subscribe(3)
+	assert.Empty(backlog)
+}
+
+func TestEventExporterDropsSlowSubscribers(t *testing.T) {
+	e := newEventExporter(100)
+	ch, _ := e.subscribe(0)
+	for i := 0; i < 20; i++ {
+		e.handle(about{Service: service{Name: string(rune('a' + i)), Namespace: "billing"}})
+	}
+
+	received := 0
+	for range ch {
+		received++
+	}
+	assert.Equal(t, 10, received)
+	assert.Empty(t, e.subscribers)
+}
+
+func TestEventExporterHandler(t *testing.T) {
+	assert := assert.New(t)
+	e := newEventExporter(10)
+	e.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})
+	e.handle(about{Service: service{Name: "uw-service-account", Namespace: "billing"}})
+	server := httptest.NewServer(http.HandlerFunc(e.handleHTTP))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal("id: 2\nevent: add\n", readLines(reader, 2))
+	assert.Contains(readLines(reader, 2), "\"Name\":\"uw-service-account\"")
+
+	e.remove(service{Name: "uw-service-refdata", Namespace: "billing"})
+	assert.Equal("id: 3\nevent: remove\n", readLines(reader, 2))
+	assert.Contains(readLines(reader, 2), "\"Name\":\"uw-service-refdata\"")
+}
+
+func TestEventExporterHandlerInvalidLastEventID(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__/about/events", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	newEventExporter(10).handleHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func ids(events []event) []uint64 {
+	r := []uint64{}
+	for _, ev := range events {
+		r = append(r, ev.ID)
+	}
+	return r
+}
+
+func readLines(r *bufio.Reader, n int) string {
+	lines := []string{}
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "")
+}
@@ -2,56 +2,148 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"sync"
-)
+	"time"
 
-const confluenceTemplatePath = "confluence.html"
+	"github.com/gorilla/mux"
+)
 
 type exporterService struct {
 	exporters []exporter
+	health    *exporterHealth
 }
 
+// exporter publishes the catalogue somewhere. handle and remove return
+// whether they published anything, a change that is only queued or that
+// changes nothing not counting as a success.
 type exporter interface {
-	handle(about about) error
+	handle(about about) (bool, error)
+	remove(service service) (bool, error)
+}
+
+// primer is implemented by exporters that need the services found by the
+// first complete discovery run, e.g. to tell them from services added later.
+type primer interface {
+	prime(services []service) error
+}
+
+// prime primes the exporters with the first run read from runs, and drains
+// the later ones.
+func (e *exporterService) prime(runs chan []service, errors chan error) {
+	first := true
+	for services := range runs {
+		if !first {
+			continue
+		}
+		first = false
+		for _, ex := range e.exporters {
+			if p, ok := ex.(primer); ok {
+				if err := p.prime(services); err != nil {
+					reportError(errors, fmt.Errorf("Error while priming %v: (%v)", exporterName(ex), err))
+				}
+			}
+		}
+	}
 }
 
 func (e *exporterService) export(about chan about, errors chan error) {
 	for a := range about {
 		for _, ex := range e.exporters {
+			name := exporterName(ex)
+			exportsInFlight.WithLabelValues(name).Inc()
 			go func(exporter exporter) {
-				err := exporter.handle(a)
+				defer exportsInFlight.WithLabelValues(name).Dec()
+				published, err := exporter.handle(a)
+				e.health.recordExport(exporter, published, err)
 				if err != nil {
-					select {
-					case errors <- fmt.Errorf("Error while exporting: (%v)", err):
-					default:
-						return
-					}
+					exportErrors.WithLabelValues(name).Inc()
+					reportError(errors, fmt.Errorf("Error while exporting: (%v)", err))
 				}
 			}(ex)
 		}
 	}
 }
 
-func newHTTPExporter() *httpExporter {
-	return &httpExporter{mutex: sync.RWMutex{}, abouts: make(map[string]about)}
+func (e *exporterService) exportRemovals(services chan service, errors chan error) {
+	for s := range services {
+		for _, ex := range e.exporters {
+			name := exporterName(ex)
+			exportsInFlight.WithLabelValues(name).Inc()
+			go func(exporter exporter, s service) {
+				defer exportsInFlight.WithLabelValues(name).Dec()
+				published, err := exporter.remove(s)
+				e.health.recordExport(exporter, published, err)
+				if err != nil {
+					exportErrors.WithLabelValues(name).Inc()
+					reportError(errors, fmt.Errorf("Error while exporting removal of %v: (%v)", s.key(), err))
+				}
+			}(ex, s)
+		}
+	}
+}
+
+func newHTTPExporter(templates *template.Template, scorer *scorer, policies *policyChecker, health *exporterHealth) *httpExporter {
+	return &httpExporter{templates: templates, scorer: scorer, policies: policies, health: health, mutex: sync.RWMutex{}, abouts: make(map[string]about), modified: make(map[string]time.Time)}
 }
 
 type httpExporter struct {
-	mutex  sync.RWMutex //protects abouts
-	abouts map[string]about
+	templates    *template.Template
+	scorer       *scorer
+	policies     *policyChecker
+	health       *exporterHealth
+	mutex        sync.RWMutex //protects abouts, modified and lastModified
+	abouts       map[string]about
+	modified     map[string]time.Time
+	lastModified time.Time
 }
 
-func (h *httpExporter) handle(about about) error {
+func (h *httpExporter) handle(about about) (bool, error) {
 	h.mutex.Lock()
-	h.abouts[about.Service.Name] = about
-	h.mutex.Unlock()
-	return nil
+	defer h.mutex.Unlock()
+	key := about.Service.key()
+	if existing, ok := h.abouts[key]; ok && existing.sameContent(about) {
+		return false, nil
+	}
+	now := time.Now()
+	h.abouts[key] = about
+	h.modified[key] = now
+	h.lastModified = now
+	return true, nil
+}
+
+func (h *httpExporter) remove(service service) (bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := service.key()
+	if _, ok := h.abouts[key]; !ok {
+		return false, nil
+	}
+	delete(h.abouts, key)
+	delete(h.modified, key)
+	h.lastModified = time.Now()
+	return true, nil
+}
+
+// list returns the known abouts sorted by namespace and name, together with
+// the time the catalogue last changed.
+func (h *httpExporter) list() ([]about, time.Time) {
+	a := []about{}
+	h.mutex.RLock()
+	for _, about := range h.abouts {
+		a = append(a, about)
+	}
+	lastModified := h.lastModified
+	h.mutex.RUnlock()
+	sort.Slice(a, func(i, j int) bool { return a[i].Service.key() < a[j].Service.key() })
+	return a, lastModified
 }
 
 func (h *httpExporter) handleHTTP(w http.ResponseWriter, r *http.Request) {
@@ -62,45 +154,82 @@ func (h *httpExporter) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *httpExporter) jsonHandler(w http.ResponseWriter, r *http.Request) {
-	a := []about{}
+func (h *httpExporter) handleServiceHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := service{Namespace: vars["namespace"], Name: vars["name"]}.key()
 	h.mutex.RLock()
-	for _, about := range h.abouts {
-		a = append(a, about)
-	}
+	a, ok := h.abouts[key]
+	modified := h.modified[key]
 	h.mutex.RUnlock()
-	enc := json.NewEncoder(w)
-	err := enc.Encode(a)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Error during json encoding"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Service not found"))
+		return
+	}
+	if r.Header.Get("Accept") == "application/json" {
+		writeJSON(w, r, a, modified)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
+	// the score depends on the time, so only the ETag tells whether the
+	// page changed
+	abouts, _ := h.list()
+	h.writeHTML(w, r, "service.html", newServicePage(a, findProblems(abouts), h.scorer, time.Now()), time.Time{})
+}
+
+// servicePage is the data of the service.html template.
+type servicePage struct {
+	About    about
+	Problems []problem
+	Score    serviceScore
+}
+
+func newServicePage(a about, problems map[string][]problem, scorer *scorer, now time.Time) servicePage {
+	return servicePage{About: a, Problems: problems[a.Service.key()], Score: scorer.score(a, now)}
+}
+
+func (h *httpExporter) jsonHandler(w http.ResponseWriter, r *http.Request) {
+	a, lastModified := h.list()
+	writeJSON(w, r, a, lastModified)
 }
 
 func (h *httpExporter) htmlHandler(w http.ResponseWriter, r *http.Request) {
-	a := []about{}
-	h.mutex.RLock()
-	for _, about := range h.abouts {
-		a = append(a, about)
-	}
-	h.mutex.RUnlock()
-	w.Header().Add("Content-Type", "text/html")
-	mainTemplate, err := template.ParseFiles("main.html")
-	if err != nil {
+	// scores depend on the time, so only the ETag tells whether the page
+	// changed
+	a, _ := h.list()
+	h.writeHTML(w, r, "main.html", newCatalogueView(a, r.URL.Query().Get("group"), h.scorer.scoreboard(a, time.Now())), time.Time{})
+}
+
+func (h *httpExporter) writeHTML(w http.ResponseWriter, r *http.Request, name string, data interface{}, modified time.Time) {
+	var b bytes.Buffer
+	if err := h.templates.ExecuteTemplate(&b, name, data); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Couldn't open template file for html response"))
+		w.Write([]byte("Couldn't render template file for html response"))
 		return
 	}
-	if err = mainTemplate.Execute(w, struct{ Abouts []about }{Abouts: a}); err != nil {
+	w.Header().Set("Content-Type", "text/html")
+	serveContent(w, r, b.Bytes(), modified)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}, modified time.Time) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Couldn't render template file for html response"))
+		w.Write([]byte("Error during json encoding"))
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	serveContent(w, r, b.Bytes(), modified)
+}
+
+// serveContent writes content with an ETag derived from its hash and lets
+// http.ServeContent answer If-None-Match and If-Modified-Since with a 304.
+func serveContent(w http.ResponseWriter, r *http.Request, content []byte, modified time.Time) {
+	w.Header().Set("ETag", fmt.Sprintf("\"%x\"", sha1.Sum(content)))
+	w.Header().Set("Vary", "Accept")
+	http.ServeContent(w, r, "", modified, bytes.NewReader(content))
 }
 
-func newConfluenceExporter(confluenceHost string, confluenceCredentials string, confluencePageID string, client httpClient) (*confluenceExporter, error) {
+func newConfluenceExporter(confluenceHost string, confluenceCredentials string, confluencePageID string, templates *template.Template, scorer *scorer, client httpClient) (*confluenceExporter, error) {
 	if confluenceHost == "" {
 		return nil, fmt.Errorf("confluenceHost is required")
 	}
@@ -111,6 +240,8 @@ func newConfluenceExporter(confluenceHost string, confluenceCredentials string,
 		confluenceHost:        confluenceHost,
 		confluenceCredentials: confluenceCredentials,
 		confluencePageID:      confluencePageID,
+		templates:             templates,
+		scorer:                scorer,
 		client:                client,
 		mutex:                 sync.Mutex{},
 		abouts:                make(map[string]about)}, nil
@@ -120,25 +251,46 @@ type confluenceExporter struct {
 	confluenceHost        string
 	confluenceCredentials string
 	confluencePageID      string
+	templates             *template.Template
+	scorer                *scorer
 	client                httpClient
 	mutex                 sync.Mutex //protects abouts
 	abouts                map[string]about
 }
 
-func (h *confluenceExporter) handle(ab about) error {
+func (h *confluenceExporter) handle(ab about) (bool, error) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
-	h.abouts[ab.Service.Name] = ab
+	h.abouts[ab.Service.key()] = ab
+	err := h.publish()
+	return err == nil, err
+}
+
+func (h *confluenceExporter) remove(service service) (bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.abouts[service.key()]; !ok {
+		return false, nil
+	}
+	delete(h.abouts, service.key())
+	err := h.publish()
+	return err == nil, err
+}
+
+// publish renders all known abouts and replaces the confluence page body.
+// Callers must hold the mutex.
+func (h *confluenceExporter) publish() error {
 	a := []about{}
 	for _, about := range h.abouts {
 		a = append(a, about)
 	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Service.key() < a[j].Service.key() })
 	var b bytes.Buffer
-	mainTemplate, err := template.ParseFiles(confluenceTemplatePath)
-	if err != nil {
-		return fmt.Errorf("Couldn't find template file for confluence page body: (%v)", err)
-	}
-	if err = mainTemplate.Execute(&b, struct{ Abouts []about }{Abouts: a}); err != nil {
+	data := struct {
+		Abouts []about
+		Scores scoreboard
+	}{Abouts: a, Scores: h.scorer.scoreboard(a, time.Now())}
+	if err := h.templates.ExecuteTemplate(&b, "confluence.html", data); err != nil {
 		return fmt.Errorf("Couldn't render template file for confluence page body: (%v)", err)
 	}
 
@@ -161,7 +313,9 @@ func (h *confluenceExporter) getConfluencePage(pageID string) (confluencePage, e
 		return confluencePage{}, fmt.Errorf("Could not create get page request for %v: (%v)", h.confluencePageID, err)
 	}
 	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", h.confluenceCredentials))
+	start := time.Now()
 	resp, err := h.client.Do(req)
+	confluenceRequestDuration.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
 	if err != nil {
 		return confluencePage{}, fmt.Errorf("Could not get response from %v: (%v)", req.URL.String(), err)
 	}
@@ -189,7 +343,9 @@ func (h *confluenceExporter) updateConfluencePage(newPage confluencePage) error
 	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", h.confluenceCredentials))
+	start := time.Now()
 	resp, err := h.client.Do(req)
+	confluenceRequestDuration.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
 	if err != nil {
 		return fmt.Errorf("Could not get response from %v: (%v)", req.URL.String(), err)
 	}
@@ -13,14 +13,13 @@ import (
 	"time"
 )
 
-const htmlResponse = "<!DOCTYPE html>\n<head>\n    <title>UW Documentation</title>\n</head>\n<body>\n<h1>UW Documented services</h1>\n<table style='font-size: 10pt; font-family: MONOSPACE;'>\n    \n    \n    <tr>\n        <td><a href=\"/../../../billing/services/uw-service-refdata:80/__/about\">billing.uw-service-refdata</a></td>\n    </tr>\n    \n    \n</table>\n</body>\n</html>"
-const jsonResponse = "[{\"Service\":{\"Name\":\"uw-service-refdata\",\"Namespace\":\"billing\",\"BaseURL\":\"\"},\"Doc\":{\"name\":\"uw-service-refdata\",\"description\":\"uw-service-refdata\",\"owners\":[{\"name\":\"Billing\",\"slack\":\"#billing\"}],\"links\":[{\"url\":\"http://readme\",\"description\":\"readme\"}],\"build-info\":{\"revision\":\"revision\"}}}]"
+const jsonResponse = "[{\"Service\":{\"Name\":\"uw-service-refdata\",\"Namespace\":\"billing\",\"BaseURL\":\"\"},\"Doc\":{\"name\":\"uw-service-refdata\",\"description\":\"uw-service-refdata\",\"owners\":[{\"name\":\"Billing\",\"slack\":\"#billing\"}],\"links\":[{\"url\":\"http://readme\",\"description\":\"readme\"}],\"build-info\":{\"revision\":\"revision\"}},\"Fetched\":\"0001-01-01T00:00:00Z\"}]"
 
 func TestExporterService(t *testing.T) {
 	errors := make(chan error, 10)
 	ab := make(chan about, 10)
-	exporters := []exporter{newHTTPExporter(), newHTTPExporter()}
-	e := exporterService{exporters: exporters}
+	exporters := []exporter{newHTTPExporter(testTemplates, testScorer, testPolicies, newExporterHealth(3)), newHTTPExporter(testTemplates, testScorer, testPolicies, newExporterHealth(3))}
+	e := exporterService{exporters: exporters, health: newExporterHealth(3)}
 	ab <- about{}
 	close(ab)
 	close(errors)
@@ -40,8 +39,70 @@ func TestExporterService(t *testing.T) {
 
 }
 
+func TestExporterServiceRemovals(t *testing.T) {
+	errors := make(chan error, 10)
+	removed := make(chan service, 10)
+	s := service{Name: "uw-service-refdata", Namespace: "billing"}
+	exporters := []exporter{createHTTPExporterAndHandle(about{Service: s}), createHTTPExporterAndHandle(about{Service: s})}
+	e := exporterService{exporters: exporters, health: newExporterHealth(3)}
+	removed <- s
+	close(removed)
+	close(errors)
+	e.exportRemovals(removed, errors)
+	//give the exporters a chance to process as they run in different go routines
+	time.Sleep(1 * time.Second)
+
+	for _, ex := range e.exporters {
+		assert.Equal(t, 0, func() int {
+			ex.(*httpExporter).mutex.RLock()
+			l := len(ex.(*httpExporter).abouts)
+			ex.(*httpExporter).mutex.RUnlock()
+			return l
+		}())
+	}
+}
+
+// outcome describes what handling or removing a service did, for tests to
+// compare.
+func outcome(published bool, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if published {
+		return "published"
+	}
+	return "nothing published"
+}
+
+func TestExporterServicePrimesOnFirstRun(t *testing.T) {
+	assert := assert.New(t)
+	errors := make(chan error, 10)
+	runs := make(chan []service, 2)
+	slack, err := newSlackExporter("http://slack", nil, false, http.DefaultClient)
+	assert.NoError(err)
+	e := exporterService{exporters: []exporter{newHTTPExporter(testTemplates, testScorer, testPolicies, newExporterHealth(3)), slack}, health: newExporterHealth(3)}
+	refdata := service{Name: "uw-service-refdata", Namespace: "billing"}
+	runs <- []service{refdata}
+	runs <- []service{refdata, {Name: "uw-service-crm", Namespace: "crm"}}
+	close(runs)
+	e.prime(runs, errors)
+
+	assert.True(slack.primed)
+	assert.Equal(map[string]bool{refdata.key(): true}, slack.startup)
+	assert.Empty(errors)
+}
+
 func TestHTTPExporterHandler(t *testing.T) {
 	assert := assert.New(t)
+	refdata := about{
+		Service: service{Name: "uw-service-refdata", Namespace: "billing"},
+		Doc: doc{
+			Name:        "uw-service-refdata",
+			Description: "uw-service-refdata",
+			Owners:      []owner{{Name: "Billing", Slack: "#billing"}},
+			Links:       []link{{URL: "http://readme", Description: "readme"}},
+			BuildInfo:   buildInfo{Revision: "revision"},
+		}}
 	tests := []struct {
 		name        string
 		req         *http.Request
@@ -49,30 +110,76 @@ func TestHTTPExporterHandler(t *testing.T) {
 		statusCode  int
 		contentType string // Contents of the Content-Type header
 		body        string
+		contains    []string
 	}{
-		{"Success html", newRequest("GET", "/__/about", "text/html", nil), createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}), http.StatusOK, "text/html", htmlResponse},
-		{"Success json", newRequest("GET", "/__/about", "application/json", nil), createHTTPExporterAndHandle(about{
+		{"Success html", newRequest("GET", "/__/about", "text/html", nil), createHTTPExporterAndHandle(refdata), http.StatusOK, "text/html", "", []string{
+			"<h2>billing <span class=\"muted\">(1)</span></h2>",
+			"<a href=\"/__/about/billing/uw-service-refdata\">billing.uw-service-refdata</a>",
+			"<a href=\"/__/owners/Billing\">Billing</a> (<a href=\"https://slack.com/app_redirect?channel=billing\">#billing</a>)",
+			"<li><a href=\"http://readme\">readme</a></li>",
+			"Revision <code>revision</code>",
+			"Fetched never",
+			"<a href=\"/../../../billing/services/uw-service-refdata:80/__/about\">raw</a>",
+		}},
+		{"Success html grouped by owner", newRequest("GET", "/__/about?group=owner", "text/html", nil), createHTTPExporterAndHandle(refdata), http.StatusOK, "text/html", "", []string{
+			"<h2>Billing <span class=\"muted\">(1)</span></h2>",
+		}},
+		{"Success html with optional fields", newRequest("GET", "/__/about/billing/uw-service-refdata", "text/html", nil), createHTTPExporterAndHandle(about{
+			Service: service{Name: "uw-service-refdata", Namespace: "billing"},
+			Doc:     doc{Tier: 1, Lifecycle: lifecycleProduction, Tags: []string{"pricing"}, Runbook: "https://wiki/runbook", OnCall: "PD1234", Repository: "https://github.com/utilitywarehouse/uw-service-refdata"},
+		}), http.StatusOK, "text/html", "", []string{
+			"<span class=\"badge\">tier 1</span>",
+			"<span class=\"badge production\">production</span>",
+			"<span class=\"tag\">pricing</span>",
+			"<a href=\"https://wiki/runbook\">https://wiki/runbook</a>",
+			"<dd>PD1234</dd>",
+		}},
+		{"Success html with build info", newRequest("GET", "/__/about/billing/uw-service-refdata", "text/html", nil), createHTTPExporterAndHandle(about{
+			Service: service{Name: "uw-service-refdata", Namespace: "billing"},
+			Doc:     doc{BuildInfo: buildInfo{Revision: "abc", Version: "1.2.3", BuildTime: "2017-03-07T12:00:00Z", Branch: "master", Builder: "circleci", CIJob: "https://circleci/42"}},
+		}), http.StatusOK, "text/html", "", []string{
+			"<li>Version: <code>1.2.3</code></li>",
+			"<li>Built: 2017-03-07T12:00:00Z (<time class=\"age\" datetime=\"2017-03-07T12:00:00Z\">2017-03-07T12:00:00Z</time>)</li>",
+			"<li>Branch: <code>master</code></li>",
+			"<li>Builder: circleci</li>",
+			"<li>CI job: <a href=\"https://circleci/42\">https://circleci/42</a></li>",
+		}},
+		{"Success html with unknown fields", newRequest("GET", "/__/about/billing/uw-service-refdata", "text/html", nil), createHTTPExporterAndHandle(about{
+			Service: service{Name: "uw-service-refdata", Namespace: "billing"},
+			Doc:     doc{Extra: map[string]interface{}{"slo": map[string]interface{}{"availability": "99.9%"}}},
+		}), http.StatusOK, "text/html", "", []string{
+			"<li>slo: <code>{&#34;availability&#34;:&#34;99.9%&#34;}</code></li>",
+		}},
+		{"Success json with unknown fields", newRequest("GET", "/__/about/billing/uw-service-refdata", "application/json", nil), createHTTPExporterAndHandle(about{
 			Service: service{Name: "uw-service-refdata", Namespace: "billing"},
-			Doc: doc{
-				Name:        "uw-service-refdata",
-				Description: "uw-service-refdata",
-				Owners:      []owner{{Name: "Billing", Slack: "#billing"}},
-				Links:       []link{{URL: "http://readme", Description: "readme"}},
-				BuildInfo:   buildInfo{Revision: "revision"},
-			}}),
-			http.StatusOK, "application/json", jsonResponse},
+			Doc:     doc{Extra: map[string]interface{}{"slo": "99.9%"}},
+		}), http.StatusOK, "application/json", "", []string{
+			"\"build-info\":{\"revision\":\"\"},\"slo\":\"99.9%\"}",
+		}},
+		{"Success json", newRequest("GET", "/__/about", "application/json", nil), createHTTPExporterAndHandle(refdata), http.StatusOK, "application/json", jsonResponse, nil},
+		{"Success service html", newRequest("GET", "/__/about/billing/uw-service-refdata", "text/html", nil), createHTTPExporterAndHandle(refdata), http.StatusOK, "text/html", "", []string{
+			"<h1>billing.uw-service-refdata <span class=\"status ok\">OK</span></h1>",
+			"<li><a href=\"/__/owners/Billing\">Billing</a> - <a href=\"https://slack.com/app_redirect?channel=billing\">#billing</a></li>",
+			"Revision: <code>revision</code>",
+		}},
 	}
 
 	for _, test := range tests {
 		rec := httptest.NewRecorder()
 		router(test.exporter).ServeHTTP(rec, test.req)
 		assert.True(test.statusCode == rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
-		assert.Equal(strings.TrimSpace(test.body), strings.TrimSpace(rec.Body.String()), fmt.Sprintf("%s: Wrong body", test.name))
+		assert.Equal(test.contentType, rec.Header().Get("Content-Type"), fmt.Sprintf("%s: Wrong content type", test.name))
+		if test.body != "" {
+			assert.Equal(strings.TrimSpace(test.body), strings.TrimSpace(rec.Body.String()), fmt.Sprintf("%s: Wrong body", test.name))
+		}
+		for _, c := range test.contains {
+			assert.Contains(rec.Body.String(), c, fmt.Sprintf("%s: Wrong body", test.name))
+		}
 	}
 }
 
 func createHTTPExporterAndHandle(about about) *httpExporter {
-	httpExporter := newHTTPExporter()
+	httpExporter := newHTTPExporter(testTemplates, testScorer, testPolicies, newExporterHealth(3))
 	httpExporter.handle(about)
 	return httpExporter
 }
@@ -89,9 +196,126 @@ func newRequest(method, url string, accept string, body io.Reader) *http.Request
 func router(e *httpExporter) *mux.Router {
 	m := mux.NewRouter()
 	m.HandleFunc("/__/about", e.handleHTTP).Methods("GET")
+	m.HandleFunc("/__/about/{namespace}/{name}", e.handleServiceHTTP).Methods("GET")
+	m.HandleFunc("/__/owners", e.handleOwnersHTTP).Methods("GET")
+	m.HandleFunc("/__/owners/{name:.+}", e.handleOwnerHTTP).Methods("GET")
+	m.HandleFunc("/__/graph", e.handleGraphHTTP).Methods("GET")
+	m.HandleFunc("/__/status", e.handleStatusHTTP).Methods("GET")
+	m.HandleFunc("/__/lint", e.handleLintHTTP).Methods("GET")
+	m.HandleFunc("/__/scores", e.handleScoresHTTP).Methods("GET")
+	m.HandleFunc("/__/policies", e.handlePoliciesHTTP).Methods("GET")
+	m.HandleFunc("/__/graph/{namespace}/{name}/{direction:upstream|downstream}", e.handleServiceGraphHTTP).Methods("GET")
 	return m
 }
 
+func TestHTTPExporterServiceHandler(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata"}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about/billing/uw-service-refdata", "application/json", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("application/json", rec.Header().Get("Content-Type"))
+	assert.Contains(rec.Body.String(), "\"name\":\"uw-service-refdata\"")
+
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about/billing/unknown", "application/json", nil))
+	assert.Equal(http.StatusNotFound, rec.Code)
+}
+
+func TestHTTPExporterShowsBrokenServices(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Broken: "/__/about returned 404"})
+	e.handle(about{Service: service{Name: "uw-service-crm", Namespace: "crm", Unlabelled: true}, Doc: doc{Description: "crm"}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about", "text/html", nil))
+	assert.Contains(rec.Body.String(), "<span class=\"status broken\">broken</span>")
+	assert.Contains(rec.Body.String(), "<li>/__/about returned 404</li>")
+	assert.Contains(rec.Body.String(), "<span class=\"status warning\">unlabelled</span>")
+
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about/billing/uw-service-refdata", "application/json", nil))
+	assert.Contains(rec.Body.String(), "\"Broken\":\"/__/about returned 404\"")
+}
+
+func TestHTTPExporterConditionalGet(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata"}})
+
+	for _, url := range []string{"/__/about", "/__/about/billing/uw-service-refdata"} {
+		rec := httptest.NewRecorder()
+		router(e).ServeHTTP(rec, newRequest("GET", url, "application/json", nil))
+		assert.Equal(http.StatusOK, rec.Code, url)
+		etag := rec.Header().Get("ETag")
+		lastModified := rec.Header().Get("Last-Modified")
+		assert.NotEmpty(etag, url)
+		assert.NotEmpty(lastModified, url)
+
+		req := newRequest("GET", url, "application/json", nil)
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		router(e).ServeHTTP(rec, req)
+		assert.Equal(http.StatusNotModified, rec.Code, url)
+		assert.Empty(rec.Body.String(), url)
+
+		req = newRequest("GET", url, "application/json", nil)
+		req.Header.Set("If-Modified-Since", lastModified)
+		rec = httptest.NewRecorder()
+		router(e).ServeHTTP(rec, req)
+		assert.Equal(http.StatusNotModified, rec.Code, url)
+
+		req = newRequest("GET", url, "application/json", nil)
+		req.Header.Set("If-None-Match", "\"stale\"")
+		rec = httptest.NewRecorder()
+		router(e).ServeHTTP(rec, req)
+		assert.Equal(http.StatusOK, rec.Code, url)
+	}
+}
+
+func TestHTTPExporterScoredPagesHaveNoLastModified(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata"}})
+
+	for _, r := range []struct{ url, accept string }{
+		{"/__/about", "text/html"},
+		{"/__/about/billing/uw-service-refdata", "text/html"},
+		{"/__/scores", "text/html"},
+		{"/__/scores", "application/json"},
+	} {
+		req := newRequest("GET", r.url, r.accept, nil)
+		req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		rec := httptest.NewRecorder()
+		router(e).ServeHTTP(rec, req)
+		assert.Equal(http.StatusOK, rec.Code, r.url)
+		assert.Empty(rec.Header().Get("Last-Modified"), r.url)
+		assert.NotEmpty(rec.Header().Get("ETag"), r.url)
+	}
+}
+
+func TestHTTPExporterETagChangesOnUpdate(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata"}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about", "application/json", nil))
+	etag := rec.Header().Get("ETag")
+
+	lastModified := e.lastModified
+
+	// refetching an unchanged about changes neither the ETag nor Last-Modified
+	e.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata"}, Fetched: time.Now()})
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about", "application/json", nil))
+	assert.Equal(etag, rec.Header().Get("ETag"))
+	assert.Equal(lastModified, e.lastModified)
+
+	e.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata", BuildInfo: buildInfo{Revision: "new"}}})
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about", "application/json", nil))
+	assert.NotEqual(etag, rec.Header().Get("ETag"))
+}
+
 const confluenceURL = "https://utilitywarehouse.atlassian.net"
 const confluencePageID = "1234"
 const confluenceGetPageResponse = "{\"type\":\"page\",\"title\":\"some page\",\"version\":{\"number\":%d}}"
@@ -198,8 +422,8 @@ func TestConfluenceExporterHandler(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		confluenceExporter, _ := newConfluenceExporter(confluenceURL, confluenceCredentials, confluencePageID, &test.client)
-		err := confluenceExporter.handle(test.ab)
+		confluenceExporter, _ := newConfluenceExporter(confluenceURL, confluenceCredentials, confluencePageID, testTemplates, testScorer, &test.client)
+		_, err := confluenceExporter.handle(test.ab)
 		assert.Equal(test.err, err)
 	}
 
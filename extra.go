@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// docFields are the lower-cased json names of the fields of doc. Like
+// encoding/json, they are matched ignoring case.
+var docFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(doc{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = true
+		}
+	}
+	return fields
+}()
+
+// UnmarshalJSON decodes the known fields of an about document and keeps
+// the others in Extra. Numbers in Extra are kept as json.Number so that
+// they are written back unchanged.
+func (d *doc) UnmarshalJSON(data []byte) error {
+	type plain doc
+	var p plain
+	// like encoding/json, carry on after a field of the wrong type and
+	// report it once done
+	typeErr := json.Unmarshal(data, &p)
+	if _, ok := typeErr.(*json.UnmarshalTypeError); typeErr != nil && !ok {
+		return typeErr
+	}
+	var all map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&all); err != nil {
+		return err
+	}
+	p.Extra = nil
+	for k, v := range all {
+		if docFields[strings.ToLower(k)] {
+			continue
+		}
+		if p.Extra == nil {
+			p.Extra = make(map[string]interface{})
+		}
+		p.Extra[k] = v
+	}
+	*d = doc(p)
+	return typeErr
+}
+
+// MarshalJSON encodes the known fields of a doc followed by Extra, sorted
+// by name.
+func (d doc) MarshalJSON() ([]byte, error) {
+	type plain doc
+	known, err := json.Marshal(plain(d))
+	if err != nil || len(d.Extra) == 0 {
+		return known, err
+	}
+	keys := []string{}
+	for k := range d.Extra {
+		if !docFields[strings.ToLower(k)] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.Write(known[:len(known)-1])
+	for _, k := range keys {
+		name, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(d.Extra[k])
+		if err != nil {
+			return nil, err
+		}
+		b.WriteByte(',')
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
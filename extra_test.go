@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocKeepsUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+	var d doc
+	err := json.Unmarshal([]byte(`{"name":"uw-service-refdata","Description":"refdata","slo":{"availability":99.95},"team-size":12345678901234567890,"beta":true}`), &d)
+
+	assert.NoError(err)
+	assert.Equal("uw-service-refdata", d.Name)
+	assert.Equal("refdata", d.Description)
+	assert.Equal(map[string]interface{}{
+		"slo":       map[string]interface{}{"availability": json.Number("99.95")},
+		"team-size": json.Number("12345678901234567890"),
+		"beta":      true,
+	}, d.Extra)
+
+	b, err := json.Marshal(d)
+	assert.NoError(err)
+	assert.Equal(`{"name":"uw-service-refdata","description":"refdata","owners":null,"links":null,"build-info":{"revision":""},"beta":true,"slo":{"availability":99.95},"team-size":12345678901234567890}`, string(b))
+}
+
+func TestDocWithoutUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+	var d doc
+	assert.NoError(json.Unmarshal([]byte(`{"name":"uw-service-refdata"}`), &d))
+	assert.Equal(doc{Name: "uw-service-refdata"}, d)
+
+	b, err := json.Marshal(d)
+	assert.NoError(err)
+	assert.Equal(`{"name":"uw-service-refdata","description":"","owners":null,"links":null,"build-info":{"revision":""}}`, string(b))
+}
+
+func TestDocUnmarshalErrors(t *testing.T) {
+	var d doc
+	assert.Error(t, json.Unmarshal([]byte(`{"name":1}`), &d))
+	assert.Error(t, json.Unmarshal([]byte(`[]`), &d))
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	dependencyService  = "service"
+	dependencyDatabase = "database"
+	dependencyQueue    = "queue"
+	dependencyExternal = "external"
+)
+
+// graph is the dependency graph declared by the aggregated abouts. Edges
+// point from a service to what it depends on.
+type graph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+type graphNode struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Type      string `json:"type"`
+	// Catalogued is set for services aggregated from their /__/about.
+	Catalogued bool `json:"catalogued"`
+}
+
+type graphEdge struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Description string `json:"description,omitempty"`
+}
+
+// nodeID returns the id of the node a dependency of a service in namespace
+// points to. Services are identified like in the catalogue, everything else
+// by type and name.
+func (d dependency) nodeID(namespace string) string {
+	if d.kind() != dependencyService {
+		return d.kind() + ":" + d.Name
+	}
+	return d.target(namespace).key()
+}
+
+func (d dependency) kind() string {
+	if d.Type == "" {
+		return dependencyService
+	}
+	return d.Type
+}
+
+// target returns the service a service dependency points to.
+func (d dependency) target(namespace string) service {
+	if d.Namespace != "" {
+		namespace = d.Namespace
+	}
+	return service{Name: d.Name, Namespace: namespace}
+}
+
+func newGraph(abouts []about) graph {
+	nodes := make(map[string]graphNode)
+	edges := make(map[graphEdge]bool)
+	for _, a := range abouts {
+		nodes[a.Service.key()] = graphNode{ID: a.Service.key(), Name: a.Service.Name, Namespace: a.Service.Namespace, Type: dependencyService, Catalogued: true}
+	}
+	for _, a := range abouts {
+		for _, d := range a.Doc.Dependencies {
+			id := d.nodeID(a.Service.Namespace)
+			if _, ok := nodes[id]; !ok {
+				n := graphNode{ID: id, Name: d.Name, Type: d.kind()}
+				if d.kind() == dependencyService {
+					n.Namespace = d.target(a.Service.Namespace).Namespace
+				}
+				nodes[id] = n
+			}
+			edges[graphEdge{From: a.Service.key(), To: id, Description: d.Description}] = true
+		}
+	}
+	g := graph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	for e := range edges {
+		g.Edges = append(g.Edges, e)
+	}
+	g.sort()
+	return g
+}
+
+func (g graph) sort() {
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		if g.Edges[i].To != g.Edges[j].To {
+			return g.Edges[i].To < g.Edges[j].To
+		}
+		return g.Edges[i].Description < g.Edges[j].Description
+	})
+}
+
+func (g graph) node(id string) (graphNode, bool) {
+	for _, n := range g.Nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return graphNode{}, false
+}
+
+// upstream returns the part of the graph id depends on, directly or not.
+func (g graph) upstream(id string) graph {
+	return g.reachable(id, func(e graphEdge) (string, string) { return e.From, e.To })
+}
+
+// downstream returns the part of the graph depending on id, directly or
+// not, i.e. what breaks when id is down.
+func (g graph) downstream(id string) graph {
+	return g.reachable(id, func(e graphEdge) (string, string) { return e.To, e.From })
+}
+
+// reachable returns the subgraph reachable from id, following edges in the
+// direction given by ends.
+func (g graph) reachable(id string, ends func(graphEdge) (string, string)) graph {
+	visited := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Edges {
+			if from, to := ends(e); from == current && !visited[to] {
+				visited[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	sub := graph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+	for _, n := range g.Nodes {
+		if visited[n.ID] {
+			sub.Nodes = append(sub.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		if from, _ := ends(e); visited[from] {
+			sub.Edges = append(sub.Edges, e)
+		}
+	}
+	return sub
+}
+
+var dotShapes = map[string]string{
+	dependencyService:  "box",
+	dependencyDatabase: "cylinder",
+	dependencyQueue:    "cds",
+	dependencyExternal: "ellipse",
+}
+
+// dot renders the graph in the Graphviz DOT language. Dependencies that
+// aren't aggregated services are drawn dashed.
+func (g graph) dot() string {
+	var b bytes.Buffer
+	b.WriteString("digraph services {\n")
+	for _, n := range g.Nodes {
+		label := n.Name
+		if n.Namespace != "" {
+			label = n.Namespace + "." + n.Name
+		}
+		shape, ok := dotShapes[n.Type]
+		if !ok {
+			shape = "ellipse"
+		}
+		style := "solid"
+		if !n.Catalogued {
+			style = "dashed"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s, style=%s];\n", strconv.Quote(n.ID), strconv.Quote(label), shape, style)
+	}
+	for _, e := range g.Edges {
+		if e.Description != "" {
+			fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strconv.Quote(e.Description))
+		} else {
+			fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (h *httpExporter) handleGraphHTTP(w http.ResponseWriter, r *http.Request) {
+	a, lastModified := h.list()
+	writeGraph(w, r, newGraph(a), lastModified)
+}
+
+func (h *httpExporter) handleServiceGraphHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	a, lastModified := h.list()
+	g := newGraph(a)
+	id := service{Namespace: vars["namespace"], Name: vars["name"]}.key()
+	if _, ok := g.node(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Service not found in dependency graph"))
+		return
+	}
+	if vars["direction"] == "upstream" {
+		writeGraph(w, r, g.upstream(id), lastModified)
+	} else {
+		writeGraph(w, r, g.downstream(id), lastModified)
+	}
+}
+
+// writeGraph writes g as json, or as DOT when asked for with ?format=dot or
+// an Accept header of text/vnd.graphviz.
+func writeGraph(w http.ResponseWriter, r *http.Request, g graph, modified time.Time) {
+	if r.URL.Query().Get("format") == "dot" || r.Header.Get("Accept") == "text/vnd.graphviz" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		serveContent(w, r, []byte(g.dot()), modified)
+		return
+	}
+	writeJSON(w, r, g, modified)
+}
+
+const (
+	problemUnknownDependency = "unknown-dependency"
+	problemUnlistedNamespace = "unlisted-namespace"
+	problemDependencyCycle   = "dependency-cycle"
+)
+
+// problems reports, for each catalogued service, dependencies on services
+// missing from the catalogue and dependency cycles it is part of.
+func (g graph) problems() map[string][]problem {
+	nodes := make(map[string]graphNode)
+	namespaces := make(map[string]bool)
+	for _, n := range g.Nodes {
+		nodes[n.ID] = n
+		if n.Catalogued {
+			namespaces[n.Namespace] = true
+		}
+	}
+	problems := make(map[string][]problem)
+	for i, e := range g.Edges {
+		to := nodes[e.To]
+		if to.Type != dependencyService || to.Catalogued {
+			continue
+		}
+		if i > 0 && g.Edges[i-1].From == e.From && g.Edges[i-1].To == e.To {
+			continue
+		}
+		if namespaces[to.Namespace] {
+			problems[e.From] = append(problems[e.From], problem{
+				Kind:    problemUnknownDependency,
+				Message: fmt.Sprintf("Depends on %v which is not in the catalogue", to.ID),
+			})
+		} else {
+			problems[e.From] = append(problems[e.From], problem{
+				Kind:    problemUnlistedNamespace,
+				Message: fmt.Sprintf("Depends on %v but namespace %v has no catalogued services", to.ID, to.Namespace),
+			})
+		}
+	}
+	for _, cycle := range g.cycles() {
+		for _, id := range cycle {
+			problems[id] = append(problems[id], problem{
+				Kind:    problemDependencyCycle,
+				Message: fmt.Sprintf("Part of a dependency cycle between %v", strings.Join(cycle, ", ")),
+			})
+		}
+	}
+	return problems
+}
+
+// cycles returns the sorted ids of each group of nodes depending on each
+// other, using Tarjan's strongly connected components algorithm.
+func (g graph) cycles() [][]string {
+	edges := make(map[string][]string)
+	selfLoops := make(map[string]bool)
+	for _, e := range g.Edges {
+		edges[e.From] = append(edges[e.From], e.To)
+		if e.From == e.To {
+			selfLoops[e.From] = true
+		}
+	}
+	index := 0
+	indices := make(map[string]int)
+	lowlinks := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := []string{}
+	cycles := [][]string{}
+
+	var connect func(id string)
+	connect = func(id string) {
+		indices[id] = index
+		lowlinks[id] = index
+		index++
+		stack = append(stack, id)
+		onStack[id] = true
+		for _, to := range edges[id] {
+			if _, ok := indices[to]; !ok {
+				connect(to)
+				if lowlinks[to] < lowlinks[id] {
+					lowlinks[id] = lowlinks[to]
+				}
+			} else if onStack[to] && indices[to] < lowlinks[id] {
+				lowlinks[id] = indices[to]
+			}
+		}
+		if lowlinks[id] != indices[id] {
+			return
+		}
+		component := []string{}
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == id {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoops[id] {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, n := range g.Nodes {
+		if _, ok := indices[n.ID]; !ok {
+			connect(n.ID)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	graphRefdata = about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Dependencies: []dependency{
+		{Name: "billing-db", Type: dependencyDatabase},
+	}}}
+	graphAccount = about{Service: service{Name: "uw-service-account", Namespace: "crm"}, Doc: doc{Dependencies: []dependency{
+		{Name: "uw-service-refdata", Namespace: "billing", Description: "tariffs"},
+		{Name: "uw-service-customer"},
+	}}}
+	graphPortal = about{Service: service{Name: "uw-portal", Namespace: "web"}, Doc: doc{Dependencies: []dependency{
+		{Name: "uw-service-account", Namespace: "crm"},
+		{Name: "payments", Type: dependencyExternal},
+	}}}
+)
+
+func TestNewGraph(t *testing.T) {
+	assert.Equal(t, graph{
+		Nodes: []graphNode{
+			{ID: "billing/uw-service-refdata", Name: "uw-service-refdata", Namespace: "billing", Type: dependencyService, Catalogued: true},
+			{ID: "crm/uw-service-account", Name: "uw-service-account", Namespace: "crm", Type: dependencyService, Catalogued: true},
+			{ID: "crm/uw-service-customer", Name: "uw-service-customer", Namespace: "crm", Type: dependencyService},
+			{ID: "database:billing-db", Name: "billing-db", Type: dependencyDatabase},
+			{ID: "external:payments", Name: "payments", Type: dependencyExternal},
+			{ID: "web/uw-portal", Name: "uw-portal", Namespace: "web", Type: dependencyService, Catalogued: true},
+		},
+		Edges: []graphEdge{
+			{From: "billing/uw-service-refdata", To: "database:billing-db"},
+			{From: "crm/uw-service-account", To: "billing/uw-service-refdata", Description: "tariffs"},
+			{From: "crm/uw-service-account", To: "crm/uw-service-customer"},
+			{From: "web/uw-portal", To: "crm/uw-service-account"},
+			{From: "web/uw-portal", To: "external:payments"},
+		},
+	}, newGraph([]about{graphRefdata, graphAccount, graphPortal}))
+}
+
+func TestGraphUpstreamAndDownstream(t *testing.T) {
+	assert := assert.New(t)
+	g := newGraph([]about{graphRefdata, graphAccount, graphPortal})
+
+	assert.Equal([]string{"billing/uw-service-refdata", "crm/uw-service-account", "crm/uw-service-customer", "database:billing-db"}, nodeIDs(g.upstream("crm/uw-service-account")))
+	assert.Len(g.upstream("crm/uw-service-account").Edges, 3)
+	assert.Equal([]string{"billing/uw-service-refdata", "crm/uw-service-account", "web/uw-portal"}, nodeIDs(g.downstream("billing/uw-service-refdata")))
+	assert.Equal([]graphEdge{
+		{From: "crm/uw-service-account", To: "billing/uw-service-refdata", Description: "tariffs"},
+		{From: "web/uw-portal", To: "crm/uw-service-account"},
+	}, g.downstream("billing/uw-service-refdata").Edges)
+}
+
+func TestGraphDot(t *testing.T) {
+	g := newGraph([]about{graphRefdata})
+	assert.Equal(t, "digraph services {\n"+
+		"  \"billing/uw-service-refdata\" [label=\"billing.uw-service-refdata\", shape=box, style=solid];\n"+
+		"  \"database:billing-db\" [label=\"billing-db\", shape=cylinder, style=dashed];\n"+
+		"  \"billing/uw-service-refdata\" -> \"database:billing-db\";\n"+
+		"}\n", g.dot())
+}
+
+func TestGraphHandlers(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(graphRefdata)
+	e.handle(graphAccount)
+
+	tests := []struct {
+		name        string
+		req         *http.Request
+		statusCode  int
+		contentType string
+		contains    string
+	}{
+		{"Graph json", newRequest("GET", "/__/graph", "application/json", nil), http.StatusOK, "application/json", "{\"from\":\"crm/uw-service-account\",\"to\":\"billing/uw-service-refdata\",\"description\":\"tariffs\"}"},
+		{"Graph dot", newRequest("GET", "/__/graph?format=dot", "", nil), http.StatusOK, "text/vnd.graphviz", "\"crm/uw-service-account\" -> \"billing/uw-service-refdata\" [label=\"tariffs\"];"},
+		{"Graph dot via accept", newRequest("GET", "/__/graph", "text/vnd.graphviz", nil), http.StatusOK, "text/vnd.graphviz", "digraph services {"},
+		{"Downstream", newRequest("GET", "/__/graph/billing/uw-service-refdata/downstream", "application/json", nil), http.StatusOK, "application/json", "\"id\":\"crm/uw-service-account\""},
+		{"Upstream", newRequest("GET", "/__/graph/billing/uw-service-refdata/upstream", "application/json", nil), http.StatusOK, "application/json", "\"id\":\"database:billing-db\""},
+		{"Unknown service", newRequest("GET", "/__/graph/billing/unknown/upstream", "application/json", nil), http.StatusNotFound, "", "Service not found in dependency graph"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router(e).ServeHTTP(rec, test.req)
+		assert.Equal(test.statusCode, rec.Code, test.name)
+		if test.contentType != "" {
+			assert.Equal(test.contentType, rec.Header().Get("Content-Type"), test.name)
+		}
+		assert.Contains(rec.Body.String(), test.contains, test.name)
+	}
+}
+
+func nodeIDs(g graph) []string {
+	ids := []string{}
+	for _, n := range g.Nodes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestGraphProblems(t *testing.T) {
+	assert := assert.New(t)
+	a := about{Service: service{Name: "a", Namespace: "billing"}, Doc: doc{Dependencies: []dependency{{Name: "b"}, {Name: "missing"}, {Name: "x", Namespace: "unlisted"}, {Name: "billing-db", Type: dependencyDatabase}}}}
+	b := about{Service: service{Name: "b", Namespace: "billing"}, Doc: doc{Dependencies: []dependency{{Name: "c", Namespace: "crm"}}}}
+	c := about{Service: service{Name: "c", Namespace: "crm"}, Doc: doc{Dependencies: []dependency{{Name: "a", Namespace: "billing"}}}}
+	self := about{Service: service{Name: "self", Namespace: "crm"}, Doc: doc{Dependencies: []dependency{{Name: "self"}}}}
+	ok := about{Service: service{Name: "ok", Namespace: "crm"}, Doc: doc{Dependencies: []dependency{{Name: "c"}}}}
+
+	cycle := problem{Kind: problemDependencyCycle, Message: "Part of a dependency cycle between billing/a, billing/b, crm/c"}
+	assert.Equal(map[string][]problem{
+		"billing/a": {
+			{Kind: problemUnknownDependency, Message: "Depends on billing/missing which is not in the catalogue"},
+			{Kind: problemUnlistedNamespace, Message: "Depends on unlisted/x but namespace unlisted has no catalogued services"},
+			cycle,
+		},
+		"billing/b": {cycle},
+		"crm/c":     {cycle},
+		"crm/self":  {{Kind: problemDependencyCycle, Message: "Part of a dependency cycle between crm/self"}},
+	}, newGraph([]about{a, b, c, self, ok}).problems())
+}
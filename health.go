@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// exporterState is how publishing goes for an exporter.
+type exporterState struct {
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+	Healthy  bool   `json:"healthy"`
+	// LastSuccess is when the exporter last published, nil if it didn't yet.
+	LastSuccess         *time.Time `json:"last-success,omitempty"`
+	LastError           string     `json:"last-error,omitempty"`
+	LastErrorTime       *time.Time `json:"last-error-time,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive-failures"`
+	// QueueLength is how many changes wait to be published, for the
+	// exporters that publish in the background.
+	QueueLength int `json:"queue-length"`
+}
+
+// queuer is implemented by the exporters publishing in the background.
+type queuer interface {
+	queueLength() int
+}
+
+// exporterHealth tracks the outcome of the publishing of each exporter. An
+// exporter is unhealthy after threshold consecutive failures, and the
+// aggregator is unhealthy while a critical exporter is.
+type exporterHealth struct {
+	threshold int
+	mutex     sync.RWMutex //protects exporters and states
+	exporters map[string]exporter
+	states    map[string]*exporterState
+}
+
+func newExporterHealth(threshold int) *exporterHealth {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &exporterHealth{threshold: threshold, mutex: sync.RWMutex{}, exporters: make(map[string]exporter), states: make(map[string]*exporterState)}
+}
+
+// register starts tracking exporters, critical naming those the health of
+// the aggregator depends on, e.g. confluence.
+func (h *exporterHealth) register(exporters []exporter, critical []string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, e := range exporters {
+		name := exporterName(e)
+		h.exporters[name] = e
+		if _, ok := h.states[name]; !ok {
+			h.states[name] = &exporterState{Name: name}
+		}
+	}
+	for _, name := range critical {
+		s, ok := h.states[name]
+		if !ok {
+			return fmt.Errorf("Critical exporter %v is not enabled", name)
+		}
+		s.Critical = true
+	}
+	return nil
+}
+
+// recordExport records the outcome of exporter handling a change, unless
+// it neither published nor failed.
+func (h *exporterHealth) recordExport(e exporter, published bool, err error) {
+	if !published && err == nil {
+		return
+	}
+	h.record(exporterName(e), err)
+}
+
+// record updates the state of the exporter called name after it published,
+// err saying whether it failed.
+func (h *exporterHealth) record(name string, err error) {
+	now := time.Now()
+	h.mutex.Lock()
+	s, ok := h.states[name]
+	if !ok {
+		s = &exporterState{Name: name}
+		h.states[name] = s
+	}
+	if err != nil {
+		s.LastError = err.Error()
+		s.LastErrorTime = &now
+		s.ConsecutiveFailures++
+	} else {
+		s.LastSuccess = &now
+		s.ConsecutiveFailures = 0
+	}
+	failures := s.ConsecutiveFailures
+	h.mutex.Unlock()
+	exporterConsecutiveFailures.WithLabelValues(name).Set(float64(failures))
+	if err == nil {
+		exporterLastSuccess.WithLabelValues(name).Set(float64(now.Unix()))
+	}
+}
+
+// list returns the state of every exporter, sorted by name.
+func (h *exporterHealth) list() []exporterState {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	states := []exporterState{}
+	for name, s := range h.states {
+		state := *s
+		state.Healthy = s.ConsecutiveFailures < h.threshold
+		if q, ok := h.exporters[name].(queuer); ok {
+			state.QueueLength = q.queueLength()
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// failing returns the names of the critical exporters that are unhealthy.
+func (h *exporterHealth) failing() []string {
+	failing := []string{}
+	for _, s := range h.list() {
+		if s.Critical && !s.Healthy {
+			failing = append(failing, s.Name)
+		}
+	}
+	return failing
+}
+
+// runEvery calls publish every interval for exporter e, which publishes in
+// the background, and records the outcome when publish published something
+// or failed.
+func (h *exporterHealth) runEvery(e exporter, interval time.Duration, publish func() (bool, error), errors chan<- error) {
+	name := exporterName(e)
+	for range time.Tick(interval) {
+		published, err := publish()
+		h.recordExport(e, published, err)
+		if q, ok := e.(queuer); ok {
+			exporterQueueLength.WithLabelValues(name).Set(float64(q.queueLength()))
+		}
+		if err != nil {
+			reportError(errors, err)
+		}
+	}
+}
+
+type healthReport struct {
+	Healthy   bool            `json:"healthy"`
+	Failing   []string        `json:"failing"`
+	Exporters []exporterState `json:"exporters"`
+}
+
+// handleHTTP answers 503 Service Unavailable while a critical exporter is
+// unhealthy.
+func (h *exporterHealth) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	failing := h.failing()
+	report := healthReport{Healthy: len(failing) == 0, Failing: failing, Exporters: h.list()}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// queueingExporter queues what it handles, like the exporters publishing in
+// the background.
+type queueingExporter struct {
+	queued int
+}
+
+func (q *queueingExporter) handle(about about) (bool, error) {
+	q.queued++
+	return false, nil
+}
+
+func (q *queueingExporter) remove(service service) (bool, error) {
+	q.queued++
+	return false, nil
+}
+
+func (q *queueingExporter) queueLength() int {
+	return q.queued
+}
+
+func TestExporterHealthRecordsFailures(t *testing.T) {
+	assert := assert.New(t)
+	h := newExporterHealth(2)
+	assert.NoError(h.register([]exporter{&failingExporter{}, newEventExporter(1)}, []string{"failing"}))
+
+	h.record("failing", nil)
+	h.record("failing", fmt.Errorf("first"))
+	states := h.list()
+	assert.Len(states, 2)
+	assert.Equal("event", states[0].Name)
+	assert.Nil(states[0].LastSuccess)
+	assert.True(states[0].Healthy)
+	assert.Equal("failing", states[1].Name)
+	assert.True(states[1].Critical)
+	assert.True(states[1].Healthy)
+	assert.NotNil(states[1].LastSuccess)
+	assert.Equal("first", states[1].LastError)
+	assert.Equal(1, states[1].ConsecutiveFailures)
+	assert.Empty(h.failing())
+
+	h.record("failing", fmt.Errorf("second"))
+	assert.Equal([]string{"failing"}, h.failing())
+	assert.Equal(2.0, testutil.ToFloat64(exporterConsecutiveFailures.WithLabelValues("failing")))
+
+	h.record("failing", nil)
+	assert.Empty(h.failing())
+	assert.Equal(0, h.list()[1].ConsecutiveFailures)
+	assert.Equal("second", h.list()[1].LastError)
+}
+
+func TestExporterHealthRejectsUnknownCriticalExporters(t *testing.T) {
+	assert := assert.New(t)
+	h := newExporterHealth(3)
+	assert.EqualError(h.register([]exporter{newEventExporter(1)}, []string{"confluence"}), "Critical exporter confluence is not enabled")
+}
+
+func TestExporterHealthOnlyCountsPublishedChanges(t *testing.T) {
+	assert := assert.New(t)
+	h := newExporterHealth(3)
+	q := &queueingExporter{}
+	h.register([]exporter{q}, nil)
+
+	published, err := q.handle(about{})
+	h.recordExport(q, published, err)
+	assert.Nil(h.list()[0].LastSuccess)
+	assert.Equal(1, h.list()[0].QueueLength)
+
+	h.recordExport(q, false, fmt.Errorf("failed"))
+	assert.Equal(1, h.list()[0].ConsecutiveFailures)
+}
+
+func TestExporterHealthKeepsFailuresOnUnchangedAbouts(t *testing.T) {
+	assert := assert.New(t)
+	h := newExporterHealth(3)
+	e := newEventExporter(10)
+	h.register([]exporter{e}, nil)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}}
+
+	published, err := e.handle(refdata)
+	h.recordExport(e, published, err)
+	lastSuccess := h.list()[0].LastSuccess
+	assert.NotNil(lastSuccess)
+
+	h.recordExport(e, false, fmt.Errorf("failed"))
+	published, err = e.handle(refdata)
+	h.recordExport(e, published, err)
+	assert.Equal(1, h.list()[0].ConsecutiveFailures)
+	assert.Equal(lastSuccess, h.list()[0].LastSuccess)
+
+	refdata.Doc.Description = "Reference data"
+	published, err = e.handle(refdata)
+	h.recordExport(e, published, err)
+	assert.Equal(0, h.list()[0].ConsecutiveFailures)
+}
+
+func TestExportRecordsExporterHealth(t *testing.T) {
+	assert := assert.New(t)
+	errors := make(chan error, 10)
+	ab := make(chan about, 10)
+	e := exporterService{exporters: []exporter{&failingExporter{}}, health: newExporterHealth(1)}
+	e.health.register(e.exporters, []string{"failing"})
+	ab <- about{}
+	close(ab)
+
+	e.export(ab, errors)
+	<-errors
+
+	assert.Equal([]string{"failing"}, e.health.failing())
+	assert.Equal("failed", e.health.list()[0].LastError)
+}
+
+func TestHealthHandler(t *testing.T) {
+	assert := assert.New(t)
+	h := newExporterHealth(1)
+	h.register([]exporter{&failingExporter{}, newEventExporter(1)}, []string{"event"})
+
+	rec := httptest.NewRecorder()
+	h.handleHTTP(rec, newRequest("GET", "/__/health", "", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	var report healthReport
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&report))
+	assert.True(report.Healthy)
+	assert.Len(report.Exporters, 2)
+
+	// failing exporters that aren't critical don't make the aggregator unhealthy
+	h.record("failing", fmt.Errorf("failed"))
+	rec = httptest.NewRecorder()
+	h.handleHTTP(rec, newRequest("GET", "/__/health", "", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+
+	h.record("event", fmt.Errorf("failed"))
+	rec = httptest.NewRecorder()
+	h.handleHTTP(rec, newRequest("GET", "/__/health", "", nil))
+	assert.Equal(http.StatusServiceUnavailable, rec.Code)
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&report))
+	assert.False(report.Healthy)
+	assert.Equal([]string{"event"}, report.Failing)
+}
+
+func TestStatusHandlerListsExporters(t *testing.T) {
+	assert := assert.New(t)
+	h := newExporterHealth(3)
+	e := newHTTPExporter(testTemplates, testScorer, testPolicies, h)
+	h.register([]exporter{e}, []string{"http"})
+	h.record("http", fmt.Errorf("failed"))
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/status", "application/json", nil))
+	assert.Equal(200, rec.Code)
+	var status catalogueStatus
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&status))
+	assert.Len(status.Exporters, 1)
+	assert.Equal("http", status.Exporters[0].Name)
+	assert.True(status.Exporters[0].Critical)
+	assert.Equal(1, status.Exporters[0].ConsecutiveFailures)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	problemMissingOwners      = "missing-owners"
+	problemMissingDescription = "missing-description"
+	problemMissingSlack       = "missing-slack"
+	problemInvalidURL         = "invalid-url"
+	problemBroken             = "broken"
+	problemMissingLabel       = "missing-label"
+)
+
+// lint returns what is wrong with the about document of a service on its
+// own: schema violations found when fetching it, invalid fields and missing
+// or invalid content. Broken services only get the reason they are broken.
+func lint(a about) []problem {
+	if a.Broken != "" {
+		return []problem{{Kind: problemBroken, Message: a.Broken}}
+	}
+	problems := append([]problem{}, a.Violations...)
+	if a.Service.Unlabelled {
+		problems = append(problems, problem{Kind: problemMissingLabel, Message: "Serves /__/about but lacks the discovery label"})
+	}
+	if a.Doc.Description == "" {
+		problems = append(problems, problem{Kind: problemMissingDescription, Message: "Description is empty"})
+	}
+	if len(a.Doc.Owners) == 0 {
+		problems = append(problems, problem{Kind: problemMissingOwners, Message: "No owners"})
+	}
+	for _, o := range a.Doc.Owners {
+		if o.Slack == "" {
+			problems = append(problems, problem{Kind: problemMissingSlack, Message: fmt.Sprintf("Owner %q has no slack channel", o.Name)})
+		}
+	}
+	for _, l := range a.Doc.Links {
+		if !isURL(l.URL) {
+			problems = append(problems, problem{Kind: problemInvalidURL, Message: fmt.Sprintf("Link %q is not an http(s) url", l.URL)})
+		}
+	}
+	return append(problems, a.Doc.validate()...)
+}
+
+// newLintReport lists the services whose about document has problems.
+func newLintReport(abouts []about) catalogueStatus {
+	report := catalogueStatus{Services: []serviceStatus{}}
+	for _, a := range abouts {
+		if p := lint(a); len(p) > 0 {
+			report.Services = append(report.Services, serviceStatus{Service: a.Service, Problems: p})
+		}
+	}
+	return report
+}
+
+func (h *httpExporter) handleLintHTTP(w http.ResponseWriter, r *http.Request) {
+	a, lastModified := h.list()
+	writeJSON(w, r, newLintReport(a), lastModified)
+}
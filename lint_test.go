@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	assert := assert.New(t)
+	violation := problem{Kind: problemSchemaViolation, Message: "tier: Invalid type. Expected: integer, given: string"}
+
+	assert.Empty(lint(about{Doc: doc{Description: "refdata", Owners: []owner{{Name: "Billing", Slack: "#billing"}}, Links: []link{{URL: "https://wiki"}}}}))
+	assert.Equal([]problem{
+		violation,
+		{Kind: problemMissingDescription, Message: "Description is empty"},
+		{Kind: problemMissingOwners, Message: "No owners"},
+		{Kind: problemInvalidURL, Message: "Link \"wiki/readme\" is not an http(s) url"},
+		{Kind: problemInvalidField, Message: "lifecycle must be one of experimental, production or deprecated, was \"beta\""},
+	}, lint(about{Doc: doc{Links: []link{{URL: "wiki/readme"}}, Lifecycle: "beta"}, Violations: []problem{violation}}))
+	assert.Equal([]problem{
+		{Kind: problemMissingSlack, Message: "Owner \"Billing\" has no slack channel"},
+	}, lint(about{Doc: doc{Description: "refdata", Owners: []owner{{Name: "Billing"}}}}))
+	assert.Equal([]problem{
+		{Kind: problemBroken, Message: "/__/about returned 404"},
+	}, lint(about{Broken: "/__/about returned 404"}))
+	assert.Equal([]problem{
+		{Kind: problemMissingLabel, Message: "Serves /__/about but lacks the discovery label"},
+	}, lint(about{Service: service{Unlabelled: true}, Doc: doc{Description: "refdata", Owners: []owner{{Name: "Billing", Slack: "#billing"}}}}))
+}
+
+func TestLintHandler(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "refdata", Owners: []owner{{Name: "Billing", Slack: "#billing"}}}})
+	e.handle(about{Service: service{Name: "uw-service-account", Namespace: "crm"}, Doc: doc{Owners: []owner{{Name: "Billing", Slack: "#billing"}}}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/lint", "application/json", nil))
+	assert.Equal(200, rec.Code)
+	assert.Equal("{\"services\":[{\"service\":{\"Name\":\"uw-service-account\",\"Namespace\":\"crm\",\"BaseURL\":\"\"},\"problems\":[{\"kind\":\"missing-description\",\"message\":\"Description is empty\"}]}]}\n", rec.Body.String())
+}
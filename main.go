@@ -6,15 +6,23 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/jawher/mow.cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"reflect"
+	"sync"
 	"time"
 )
 
+// eventHistory is the number of catalogue events kept for clients resuming
+// the event stream with Last-Event-ID.
+const eventHistory = 1000
+
 var client = &http.Client{
 	Transport: &http.Transport{
 		MaxIdleConnsPerHost: 128,
@@ -63,6 +71,12 @@ func main() {
 		Desc:   "Path to the kubernetes cert",
 		EnvVar: "KUBERNETES_CERT_PATH",
 	})
+	probeUnlabelled := app.Bool(cli.BoolOpt{
+		Name:   "probe-unlabelled",
+		Value:  false,
+		Desc:   "Also call /__/about on services without the label and list those that serve it",
+		EnvVar: "PROBE_UNLABELLED",
+	})
 	confluenceHost := app.String(cli.StringOpt{
 		Name:   "confluence-host",
 		Value:  "",
@@ -81,29 +95,448 @@ func main() {
 		Desc:   "Confluence page id",
 		EnvVar: "CONFLUENCE_PAGE_ID",
 	})
+	slackWebhookURL := app.String(cli.StringOpt{
+		Name:   "slack-webhook-url",
+		Value:  "",
+		Desc:   "Slack incoming webhook to post catalogue changes to, disabled if empty",
+		EnvVar: "SLACK_WEBHOOK_URL",
+	})
+	slackChannels := app.String(cli.StringOpt{
+		Name:   "slack-channels",
+		Value:  "",
+		Desc:   "Slack channels for the changes of a namespace, e.g. billing=#billing,crm=#crm",
+		EnvVar: "SLACK_CHANNELS",
+	})
+	slackNotifyOwners := app.Bool(cli.BoolOpt{
+		Name:   "slack-notify-owners",
+		Value:  false,
+		Desc:   "Also post changes to the slack channels of the owners of a service",
+		EnvVar: "SLACK_NOTIFY_OWNERS",
+	})
+	slackBatchInterval := app.String(cli.StringOpt{
+		Name:   "slack-batch-interval",
+		Value:  "1m",
+		Desc:   "How often the changes collected for slack are posted",
+		EnvVar: "SLACK_BATCH_INTERVAL",
+	})
+	webhookURLs := app.Strings(cli.StringsOpt{
+		Name:   "webhook-urls",
+		Value:  []string{},
+		Desc:   "Urls to POST catalogue events to, disabled if empty",
+		EnvVar: "WEBHOOK_URLS",
+	})
+	webhookSecret := app.String(cli.StringOpt{
+		Name:   "webhook-secret",
+		Value:  "",
+		Desc:   "Secret used to sign webhook deliveries with HMAC-SHA256",
+		EnvVar: "WEBHOOK_SECRET",
+	})
+	webhookRetries := app.Int(cli.IntOpt{
+		Name:   "webhook-retries",
+		Value:  3,
+		Desc:   "How many times a failed webhook delivery is retried",
+		EnvVar: "WEBHOOK_RETRIES",
+	})
+	webhookBackoff := app.String(cli.StringOpt{
+		Name:   "webhook-backoff",
+		Value:  "1s",
+		Desc:   "Wait before the first retry of a webhook delivery, doubled for each retry",
+		EnvVar: "WEBHOOK_BACKOFF",
+	})
+	webhookTimeout := app.String(cli.StringOpt{
+		Name:   "webhook-timeout",
+		Value:  "10s",
+		Desc:   "Timeout of a webhook delivery attempt",
+		EnvVar: "WEBHOOK_TIMEOUT",
+	})
+	webhookDeadLetterLog := app.String(cli.StringOpt{
+		Name:   "webhook-dead-letter-log",
+		Value:  "",
+		Desc:   "File the webhook deliveries failing after all retries are appended to, stderr if empty",
+		EnvVar: "WEBHOOK_DEAD_LETTER_LOG",
+	})
+	staticDir := app.String(cli.StringOpt{
+		Name:   "static-dir",
+		Value:  "",
+		Desc:   "Directory the catalogue is written to as a static site, disabled if empty",
+		EnvVar: "STATIC_DIR",
+	})
+	markdownDir := app.String(cli.StringOpt{
+		Name:   "markdown-dir",
+		Value:  "",
+		Desc:   "Git working tree to write and commit the catalogue to as markdown, disabled if empty",
+		EnvVar: "MARKDOWN_DIR",
+	})
+	markdownPath := app.String(cli.StringOpt{
+		Name:   "markdown-path",
+		Value:  "services",
+		Desc:   "Directory of the git working tree the markdown files are written to",
+		EnvVar: "MARKDOWN_PATH",
+	})
+	markdownPush := app.Bool(cli.BoolOpt{
+		Name:   "markdown-push",
+		Value:  false,
+		Desc:   "Push the markdown commits to the upstream of the checked out branch",
+		EnvVar: "MARKDOWN_PUSH",
+	})
+	markdownGitName := app.String(cli.StringOpt{
+		Name:   "markdown-git-name",
+		Value:  "uw-service-about-aggregator",
+		Desc:   "Author name of the markdown commits",
+		EnvVar: "MARKDOWN_GIT_NAME",
+	})
+	markdownGitEmail := app.String(cli.StringOpt{
+		Name:   "markdown-git-email",
+		Value:  "about-aggregator@localhost",
+		Desc:   "Author email of the markdown commits",
+		EnvVar: "MARKDOWN_GIT_EMAIL",
+	})
+	elasticsearchURL := app.String(cli.StringOpt{
+		Name:   "elasticsearch-url",
+		Value:  "",
+		Desc:   "Url of the Elasticsearch or OpenSearch cluster to index services into, disabled if empty",
+		EnvVar: "ELASTICSEARCH_URL",
+	})
+	elasticsearchIndex := app.String(cli.StringOpt{
+		Name:   "elasticsearch-index",
+		Value:  "services",
+		Desc:   "Index services are written to, created with its mapping if missing",
+		EnvVar: "ELASTICSEARCH_INDEX",
+	})
+	elasticsearchBatchSize := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-batch-size",
+		Value:  100,
+		Desc:   "How many changes are sent in one bulk request at most",
+		EnvVar: "ELASTICSEARCH_BATCH_SIZE",
+	})
+	elasticsearchFlushInterval := app.String(cli.StringOpt{
+		Name:   "elasticsearch-flush-interval",
+		Value:  "5s",
+		Desc:   "How often the queued changes are sent to elasticsearch",
+		EnvVar: "ELASTICSEARCH_FLUSH_INTERVAL",
+	})
+	natsURL := app.String(cli.StringOpt{
+		Name:   "nats-url",
+		Value:  "",
+		Desc:   "Url of the NATS server to publish catalogue events to, disabled if empty",
+		EnvVar: "NATS_URL",
+	})
+	natsStream := app.String(cli.StringOpt{
+		Name:   "nats-stream",
+		Value:  "ABOUT",
+		Desc:   "JetStream stream the events are stored in, created if missing",
+		EnvVar: "NATS_STREAM",
+	})
+	natsSubject := app.String(cli.StringOpt{
+		Name:   "nats-subject",
+		Value:  "about.services",
+		Desc:   "Subject prefix of the events, followed by the namespace and name of the service",
+		EnvVar: "NATS_SUBJECT",
+	})
+	natsRetries := app.Int(cli.IntOpt{
+		Name:   "nats-retries",
+		Value:  3,
+		Desc:   "How many times publishing an event is retried before it is left queued",
+		EnvVar: "NATS_RETRIES",
+	})
+	natsBackoff := app.String(cli.StringOpt{
+		Name:   "nats-backoff",
+		Value:  "1s",
+		Desc:   "Wait before the first retry of an event, doubled for each retry",
+		EnvVar: "NATS_BACKOFF",
+	})
+	natsRetryInterval := app.String(cli.StringOpt{
+		Name:   "nats-retry-interval",
+		Value:  "30s",
+		Desc:   "How often the queued events are published again",
+		EnvVar: "NATS_RETRY_INTERVAL",
+	})
+	snapshotBucket := app.String(cli.StringOpt{
+		Name:   "snapshot-bucket",
+		Value:  "",
+		Desc:   "Bucket the catalogue snapshots are uploaded to, disabled if empty",
+		EnvVar: "SNAPSHOT_BUCKET",
+	})
+	snapshotEndpoint := app.String(cli.StringOpt{
+		Name:   "snapshot-endpoint",
+		Value:  "https://s3.amazonaws.com",
+		Desc:   "Url of S3 or of an S3 compatible store such as MinIO",
+		EnvVar: "SNAPSHOT_ENDPOINT",
+	})
+	snapshotRegion := app.String(cli.StringOpt{
+		Name:   "snapshot-region",
+		Value:  "us-east-1",
+		Desc:   "Region of the snapshot bucket",
+		EnvVar: "SNAPSHOT_REGION",
+	})
+	snapshotPrefix := app.String(cli.StringOpt{
+		Name:   "snapshot-prefix",
+		Value:  "snapshots/",
+		Desc:   "Prefix of the snapshot keys",
+		EnvVar: "SNAPSHOT_PREFIX",
+	})
+	snapshotAccessKey := app.String(cli.StringOpt{
+		Name:   "snapshot-access-key",
+		Value:  "",
+		Desc:   "Access key of the snapshot bucket",
+		EnvVar: "SNAPSHOT_ACCESS_KEY",
+	})
+	snapshotSecretKey := app.String(cli.StringOpt{
+		Name:   "snapshot-secret-key",
+		Value:  "",
+		Desc:   "Secret key of the snapshot bucket",
+		EnvVar: "SNAPSHOT_SECRET_KEY",
+	})
+	snapshotDelay := app.String(cli.StringOpt{
+		Name:   "snapshot-delay",
+		Value:  "1m",
+		Desc:   "How long changes are collected before a snapshot is taken",
+		EnvVar: "SNAPSHOT_DELAY",
+	})
+	snapshotInterval := app.String(cli.StringOpt{
+		Name:   "snapshot-interval",
+		Value:  "24h",
+		Desc:   "How often a snapshot is taken when nothing changes",
+		EnvVar: "SNAPSHOT_INTERVAL",
+	})
+	snapshotRetention := app.String(cli.StringOpt{
+		Name:   "snapshot-retention",
+		Value:  "2160h",
+		Desc:   "How long snapshots are kept, forever if 0",
+		EnvVar: "SNAPSHOT_RETENTION",
+	})
+	criticalExporters := app.Strings(cli.StringsOpt{
+		Name:   "critical-exporters",
+		Value:  []string{},
+		Desc:   "Exporters, e.g. confluence, whose failure makes /__/health report the aggregator unhealthy",
+		EnvVar: "CRITICAL_EXPORTERS",
+	})
+	exporterFailureThreshold := app.Int(cli.IntOpt{
+		Name:   "exporter-failure-threshold",
+		Value:  3,
+		Desc:   "Consecutive failures after which an exporter is unhealthy",
+		EnvVar: "EXPORTER_FAILURE_THRESHOLD",
+	})
+	backstageDir := app.String(cli.StringOpt{
+		Name:   "backstage-dir",
+		Value:  "",
+		Desc:   "Directory the Backstage catalog-info yaml of each service is written to, disabled if empty",
+		EnvVar: "BACKSTAGE_DIR",
+	})
+
+	templateDir := app.String(cli.StringOpt{
+		Name:   "template-dir",
+		Value:  "",
+		Desc:   "Directory with *.html templates overriding the built-in ones",
+		EnvVar: "TEMPLATE_DIR",
+	})
+
+	scoringRules := app.String(cli.StringOpt{
+		Name:   "scoring-rules",
+		Value:  "",
+		Desc:   "Path to a json file with the weighted rules used to score documentation, defaults to the built-in rules",
+		EnvVar: "SCORING_RULES",
+	})
+
+	policies := app.String(cli.StringOpt{
+		Name:   "policies",
+		Value:  "",
+		Desc:   "Path to a json file with the documentation policies services must follow",
+		EnvVar: "POLICIES",
+	})
+
+	app.Command("check", "Checks the catalogue of a running aggregator against the policies", func(cmd *cli.Cmd) {
+		aggregatorURL := cmd.String(cli.StringOpt{
+			Name:   "aggregator-url",
+			Value:  "http://localhost:8080",
+			Desc:   "Base url of the aggregator to check",
+			EnvVar: "AGGREGATOR_URL",
+		})
+		fail := cmd.Bool(cli.BoolOpt{
+			Name:   "fail",
+			Value:  true,
+			Desc:   "Exit with status 1 if a service violates a policy",
+			EnvVar: "POLICY_CHECK_FAIL",
+		})
+		cmd.Action = func() {
+			checker, err := loadPolicies(*policies)
+			if err != nil {
+				log.Fatalf("ERROR: Could not load policies: error=(%v)", err)
+			}
+			failing, err := checkPolicies(client, *aggregatorURL, checker, os.Stdout)
+			if err != nil {
+				log.Fatalf("ERROR: Could not check policies: error=(%v)", err)
+			}
+			if failing > 0 && *fail {
+				cli.Exit(1)
+			}
+		}
+	})
 
 	app.Action = func() {
+		templates, err := loadTemplates(*templateDir)
+		if err != nil {
+			log.Fatalf("ERROR: Could not load templates: error=(%v)", err)
+		}
+		scorer, err := loadScorer(*scoringRules)
+		if err != nil {
+			log.Fatalf("ERROR: Could not load scoring rules: error=(%v)", err)
+		}
+		policyChecker, err := loadPolicies(*policies)
+		if err != nil {
+			log.Fatalf("ERROR: Could not load policies: error=(%v)", err)
+		}
 		errors := make(chan error, 10)
 		services := make(chan service, 10)
+		removed := make(chan service, 10)
+		runs := make(chan []service, 1)
 		about := make(chan about, 10)
-		d, err := newServiceDiscovery(*kubernetesHost, *kubernetesPort, *kubernetesTokenPath, *kubernetesCertPath, *label, services, errors)
+		registerQueueMetrics(services, about, removed, errors)
+		d, err := newServiceDiscovery(*kubernetesHost, *kubernetesPort, *kubernetesTokenPath, *kubernetesCertPath, *label, *probeUnlabelled, services, removed, runs, errors)
 		if err != nil {
 			log.Fatalf("ERROR: Could not create service discovery: error=(%v)", err)
 		}
 		f := newAboutFetcher()
 		exporters := []exporter{}
-		httpExporter := newHTTPExporter()
-		confluenceExporter, err := newConfluenceExporter(*confluenceHost, *confluenceCredentials, *confluencePageID, client)
+		health := newExporterHealth(*exporterFailureThreshold)
+		httpExporter := newHTTPExporter(templates, scorer, policyChecker, health)
+		confluenceExporter, err := newConfluenceExporter(*confluenceHost, *confluenceCredentials, *confluencePageID, templates, scorer, client)
 		if err != nil {
 			log.Fatalf("ERROR: Could not create confluence exporter: error=(%v)", err)
 		}
-		exporters = append(exporters, httpExporter, confluenceExporter)
-		e := exporterService{exporters: exporters}
+		eventExporter := newEventExporter(eventHistory)
+		prometheusExporter, err := newPrometheusExporter(prometheus.DefaultRegisterer)
+		if err != nil {
+			log.Fatalf("ERROR: Could not create prometheus exporter: error=(%v)", err)
+		}
+		backstageExporter, err := newBackstageExporter(*backstageDir)
+		if err != nil {
+			log.Fatalf("ERROR: Could not create backstage exporter: error=(%v)", err)
+		}
+		exporters = append(exporters, httpExporter, confluenceExporter, eventExporter, prometheusExporter, backstageExporter)
+		if *slackWebhookURL != "" {
+			channels, err := parseSlackChannels(*slackChannels)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse slack channels: error=(%v)", err)
+			}
+			interval, err := time.ParseDuration(*slackBatchInterval)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse slack batch interval: error=(%v)", err)
+			}
+			slackExporter, err := newSlackExporter(*slackWebhookURL, channels, *slackNotifyOwners, client)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create slack exporter: error=(%v)", err)
+			}
+			exporters = append(exporters, slackExporter)
+			go health.runEvery(slackExporter, interval, slackExporter.flush, errors)
+		}
+		if len(*webhookURLs) > 0 {
+			backoff, err := time.ParseDuration(*webhookBackoff)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse webhook backoff: error=(%v)", err)
+			}
+			timeout, err := time.ParseDuration(*webhookTimeout)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse webhook timeout: error=(%v)", err)
+			}
+			var deadLetter io.Writer = os.Stderr
+			if *webhookDeadLetterLog != "" {
+				f, err := os.OpenFile(*webhookDeadLetterLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					log.Fatalf("ERROR: Could not open webhook dead-letter log: error=(%v)", err)
+				}
+				defer f.Close()
+				deadLetter = f
+			}
+			webhookExporter, err := newWebhookExporter(*webhookURLs, *webhookSecret, *webhookRetries, backoff, timeout, deadLetter, client)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create webhook exporter: error=(%v)", err)
+			}
+			exporters = append(exporters, webhookExporter)
+		}
+		if *staticDir != "" {
+			staticExporter, err := newStaticExporter(*staticDir, templates, scorer)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create static site exporter: error=(%v)", err)
+			}
+			exporters = append(exporters, staticExporter)
+		}
+		if *markdownDir != "" {
+			markdownExporter, err := newMarkdownExporter(*markdownDir, *markdownPath, *markdownPush, *markdownGitName, *markdownGitEmail)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create markdown exporter: error=(%v)", err)
+			}
+			exporters = append(exporters, markdownExporter)
+		}
+		if *elasticsearchURL != "" {
+			interval, err := time.ParseDuration(*elasticsearchFlushInterval)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse elasticsearch flush interval: error=(%v)", err)
+			}
+			elasticsearchExporter, err := newElasticsearchExporter(*elasticsearchURL, *elasticsearchIndex, *elasticsearchBatchSize, client)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create elasticsearch exporter: error=(%v)", err)
+			}
+			exporters = append(exporters, elasticsearchExporter)
+			go health.runEvery(elasticsearchExporter, interval, elasticsearchExporter.flush, errors)
+		}
+		if *natsURL != "" {
+			backoff, err := time.ParseDuration(*natsBackoff)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse nats backoff: error=(%v)", err)
+			}
+			interval, err := time.ParseDuration(*natsRetryInterval)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse nats retry interval: error=(%v)", err)
+			}
+			publisher, err := newNATSPublisher(*natsURL, *natsStream, *natsSubject, 10*time.Second)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create nats publisher: error=(%v)", err)
+			}
+			defer publisher.close()
+			busExporter, err := newBusExporter(publisher, *natsSubject, *natsRetries, backoff)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create bus exporter: error=(%v)", err)
+			}
+			exporters = append(exporters, busExporter)
+			go health.runEvery(busExporter, interval, busExporter.drain, errors)
+		}
+		if *snapshotBucket != "" {
+			delay, err := time.ParseDuration(*snapshotDelay)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse snapshot delay: error=(%v)", err)
+			}
+			interval, err := time.ParseDuration(*snapshotInterval)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse snapshot interval: error=(%v)", err)
+			}
+			retention, err := time.ParseDuration(*snapshotRetention)
+			if err != nil {
+				log.Fatalf("ERROR: Could not parse snapshot retention: error=(%v)", err)
+			}
+			store, err := newS3Store(*snapshotEndpoint, *snapshotRegion, *snapshotBucket, *snapshotAccessKey, *snapshotSecretKey, client)
+			if err != nil {
+				log.Fatalf("ERROR: Could not create snapshot store: error=(%v)", err)
+			}
+			snapshotExporter := newSnapshotExporter(store, *snapshotPrefix, interval, retention)
+			exporters = append(exporters, snapshotExporter)
+			// checking every delay whether a snapshot is due puts a burst of
+			// changes, such as the services found at startup, in one snapshot
+			go health.runEvery(snapshotExporter, delay, func() (bool, error) { return snapshotExporter.upload(time.Now()) }, errors)
+		}
+		if err := health.register(exporters, *criticalExporters); err != nil {
+			log.Fatalf("ERROR: Could not track exporter health: error=(%v)", err)
+		}
+		e := exporterService{exporters: exporters, health: health}
 		h := handler{discovery: d}
 
 		go d.getServices()
 		go f.readAbouts(services, about, errors)
 		go e.export(about, errors)
+		forgotten := make(chan service, 10)
+		go f.forgetRemovals(removed, forgotten)
+		go e.exportRemovals(forgotten, errors)
+		go e.prime(runs, errors)
 		go func() {
 			for e := range errors {
 				log.Printf("ERROR: %v", e)
@@ -113,7 +546,22 @@ func main() {
 		m := mux.NewRouter()
 		http.Handle("/", handlers.CombinedLoggingHandler(os.Stdout, m))
 		m.HandleFunc("/reload", h.reload).Methods("POST")
+		m.Handle("/metrics", promhttp.Handler()).Methods("GET")
 		m.HandleFunc("/__/about", httpExporter.handleHTTP).Methods("GET")
+		m.HandleFunc("/__/about/events", eventExporter.handleHTTP).Methods("GET")
+		m.HandleFunc("/__/about/{namespace}/{name}", httpExporter.handleServiceHTTP).Methods("GET")
+		m.HandleFunc("/__/owners", httpExporter.handleOwnersHTTP).Methods("GET")
+		m.HandleFunc("/__/owners/{name:.+}", httpExporter.handleOwnerHTTP).Methods("GET")
+		m.HandleFunc("/__/graph", httpExporter.handleGraphHTTP).Methods("GET")
+		m.HandleFunc("/__/status", httpExporter.handleStatusHTTP).Methods("GET")
+		m.HandleFunc("/__/lint", httpExporter.handleLintHTTP).Methods("GET")
+		m.HandleFunc("/__/scores", httpExporter.handleScoresHTTP).Methods("GET")
+		m.HandleFunc("/__/policies", httpExporter.handlePoliciesHTTP).Methods("GET")
+		m.HandleFunc("/__/backstage", backstageExporter.handleHTTP).Methods("GET")
+		m.HandleFunc("/__/health", health.handleHTTP).Methods("GET")
+		m.HandleFunc("/__/schema/about", schemaHandler).Methods("GET")
+		m.HandleFunc("/__/schema/about/{version}", schemaHandler).Methods("GET")
+		m.HandleFunc("/__/graph/{namespace}/{name}/{direction:upstream|downstream}", httpExporter.handleServiceGraphHTTP).Methods("GET")
 
 		log.Printf("Listening on [%v].\n", *port)
 		err = http.ListenAndServe(":"+*port, nil)
@@ -140,10 +588,14 @@ type httpClient interface {
 
 type aboutFetcher struct {
 	client httpClient
+	mutex  sync.Mutex //protects lastRead
+	// lastRead is the last about read from each service, kept when a
+	// fetch fails so that a transient failure doesn't wipe the doc.
+	lastRead map[string]about
 }
 
-func newAboutFetcher() aboutFetcher {
-	return aboutFetcher{client: client}
+func newAboutFetcher() *aboutFetcher {
+	return &aboutFetcher{client: client, mutex: sync.Mutex{}, lastRead: make(map[string]about)}
 }
 
 func (a *aboutFetcher) readAbouts(services chan service, ab chan about, errors chan error) {
@@ -151,63 +603,141 @@ func (a *aboutFetcher) readAbouts(services chan service, ab chan about, errors c
 	for i := 0; i < readers; i++ {
 		go func(services chan service, ab chan about) {
 			for s := range services {
-				req, err := http.NewRequest("GET", s.BaseURL+"__/about", nil)
+				fetched, err := a.fetch(s)
 				if err != nil {
-					select {
-					case errors <- fmt.Errorf("Could not get response from %v: (%v)", s.BaseURL, err):
-					default:
+					// unlabelled services are probed on the off chance they
+					// serve /__/about, failing is what most of them do unless
+					// they served it before
+					if s.Unlabelled && !a.read(s) {
+						continue
 					}
-					continue
+					reportError(errors, err)
 				}
-				resp, err := a.client.Do(req)
-				if err != nil {
-					select {
-					case errors <- fmt.Errorf("Could not get response from %v: (%v)", s.BaseURL, err):
-					default:
-					}
-					continue
-				}
-
-				if resp.StatusCode != http.StatusOK {
-					select {
-					case errors <- fmt.Errorf("__/about returned %d for %s", resp.StatusCode, s.BaseURL):
-					default:
-					}
-					if resp != nil && resp.Body != nil {
-						io.Copy(ioutil.Discard, resp.Body)
-						resp.Body.Close()
-					}
-					continue
-				}
-				dec := json.NewDecoder(resp.Body)
-				var doc doc
-
-				if err := dec.Decode(&doc); err != nil {
-					select {
-					case errors <- fmt.Errorf("Could not json decode __/about response for %s", s.BaseURL):
-					default:
-					}
-					continue
-				}
-				resp.Body.Close()
-				ab <- about{Service: s, Doc: doc}
+				ab <- a.merge(fetched)
 			}
 		}(services, ab)
 	}
 
 }
 
+// read tells whether an about was read from s before.
+func (a *aboutFetcher) read(s service) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	_, ok := a.lastRead[s.key()]
+	return ok
+}
+
+// forgetRemovals forgets the last about read from the removed services
+// before passing them on to forwarded.
+func (a *aboutFetcher) forgetRemovals(removed chan service, forwarded chan service) {
+	for s := range removed {
+		a.mutex.Lock()
+		delete(a.lastRead, s.key())
+		a.mutex.Unlock()
+		forwarded <- s
+	}
+	close(forwarded)
+}
+
+// merge remembers fetched when it was read and, when it is broken, gives it
+// the doc last read from the service.
+func (a *aboutFetcher) merge(fetched about) about {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.lastRead == nil {
+		a.lastRead = make(map[string]about)
+	}
+	key := fetched.Service.key()
+	if fetched.Broken == "" {
+		a.lastRead[key] = fetched
+		return fetched
+	}
+	if last, ok := a.lastRead[key]; ok {
+		fetched.Doc = last.Doc
+		fetched.Violations = last.Violations
+	}
+	return fetched
+}
+
+// fetch reads the /__/about of s. When it can't, the error is returned along
+// with a broken about saying why.
+func (a *aboutFetcher) fetch(s service) (about, error) {
+	start := time.Now()
+	defer func() { fetchDuration.Observe(time.Since(start).Seconds()) }()
+	broken := func(result string, reason string, err error) (about, error) {
+		fetches.WithLabelValues(result, discoveredBy(s)).Inc()
+		return about{Service: s, Fetched: time.Now(), Broken: reason}, err
+	}
+	req, err := http.NewRequest("GET", s.BaseURL+"__/about", nil)
+	if err != nil {
+		return broken(fetchRequestFailed, "Invalid url", fmt.Errorf("Could not get response from %v: (%v)", s.BaseURL, err))
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return broken(fetchRequestFailed, fmt.Sprintf("Request failed: %v", err), fmt.Errorf("Could not get response from %v: (%v)", s.BaseURL, err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return broken(fetchBadStatus, fmt.Sprintf("/__/about returned %d", resp.StatusCode), fmt.Errorf("__/about returned %d for %s", resp.StatusCode, s.BaseURL))
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return broken(fetchReadFailed, fmt.Sprintf("Could not read /__/about: %v", err), fmt.Errorf("Could not read __/about response for %s: (%v)", s.BaseURL, err))
+	}
+	var doc doc
+	// fields of the wrong type are left empty and reported by the schema
+	// validation rather than dropping the whole doc
+	err = json.Unmarshal(raw, &doc)
+	if _, typeErr := err.(*json.UnmarshalTypeError); err != nil && !typeErr {
+		return broken(fetchInvalidJSON, "/__/about is not json", fmt.Errorf("Could not json decode __/about response for %s", s.BaseURL))
+	}
+	violations, err := validateSchema(raw, doc.SchemaVersion)
+	if err != nil {
+		return broken(fetchNotValidated, "/__/about could not be validated", fmt.Errorf("Could not validate __/about response for %s: (%v)", s.BaseURL, err))
+	}
+	fetches.WithLabelValues(fetchOK, discoveredBy(s)).Inc()
+	return about{Service: s, Doc: doc, Fetched: time.Now(), Violations: violations}, nil
+}
+
 type about struct {
 	Service service
 	Doc     doc
+	Fetched time.Time
+	// Violations of the about schema found when fetching Doc.
+	Violations []problem `json:",omitempty"`
+	// Broken says why /__/about couldn't be read, Doc being the last one
+	// read from the service, if any.
+	Broken string `json:",omitempty"`
+}
+
+// sameContent tells whether a and b say the same about a service, ignoring
+// when they were fetched.
+func (a about) sameContent(b about) bool {
+	return reflect.DeepEqual(a.Doc, b.Doc) && a.Broken == b.Broken && reflect.DeepEqual(a.Violations, b.Violations)
 }
 
 type doc struct {
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Owners      []owner   `json:"owners"`
-	Links       []link    `json:"links"`
-	BuildInfo   buildInfo `json:"build-info"`
+	SchemaVersion string       `json:"schema-version,omitempty"`
+	Name          string       `json:"name"`
+	Description   string       `json:"description"`
+	Owners        []owner      `json:"owners"`
+	Links         []link       `json:"links"`
+	BuildInfo     buildInfo    `json:"build-info"`
+	Dependencies  []dependency `json:"dependencies,omitempty"`
+	// Tier is the criticality of the service, 1 being the most critical.
+	Tier int `json:"tier,omitempty"`
+	// Lifecycle is one of experimental, production or deprecated.
+	Lifecycle  string   `json:"lifecycle,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Runbook    string   `json:"runbook,omitempty"`
+	OnCall     string   `json:"on-call,omitempty"`
+	Repository string   `json:"repository,omitempty"`
+	// Extra holds the fields of the about document the aggregator doesn't
+	// know about. They are written back alongside the known fields.
+	Extra map[string]interface{} `json:"-"`
 }
 
 type owner struct {
@@ -220,6 +750,22 @@ type link struct {
 	Description string `json:"description"`
 }
 
+// dependency is something a service needs to work. Type is one of service
+// (the default), database, queue or external. Service dependencies without
+// a namespace are looked up in the namespace of the declaring service.
+type dependency struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
 type buildInfo struct {
 	Revision string `json:"revision"`
+	Version  string `json:"version,omitempty"`
+	// BuildTime is when the build was made, in RFC 3339 format.
+	BuildTime string `json:"build-time,omitempty"`
+	Branch    string `json:"branch,omitempty"`
+	Builder   string `json:"builder,omitempty"`
+	CIJob     string `json:"ci-job,omitempty"`
 }
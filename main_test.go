@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFetcherAboutAddedToChannel(t *testing.T) {
@@ -47,16 +48,39 @@ func TestFetcherErrorAddedToChannel(t *testing.T) {
 	fetcher := aboutFetcher{client: &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{}, err: fmt.Errorf("error calling __about")}}
 	fetcher.readAbouts(services, about, errors)
 
-	select {
-	case err := <-errors:
-		assert.EqualError(t, err, "Could not get response from http://someService.billing/: (error calling __about)")
-	case <-about:
-		t.Errorf("Should not get any about")
-	}
+	assert.EqualError(t, <-errors, "Could not get response from http://someService.billing/: (error calling __about)")
+	broken := <-about
+	assert.Equal(t, expectedService, broken.Service)
+	assert.Equal(t, "Request failed: error calling __about", broken.Broken)
+	assert.Equal(t, doc{}, broken.Doc)
 	close(about)
 	close(errors)
 }
 
+func TestFetcherKeepsLastDocOfBrokenService(t *testing.T) {
+	a := assert.New(t)
+	errors := make(chan error, 10)
+	about := make(chan about, 10)
+	s := service{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/"}
+	fetch := func(fetcher *aboutFetcher) {
+		services := make(chan service, 1)
+		services <- s
+		close(services)
+		fetcher.readAbouts(services, about, errors)
+	}
+
+	fetcher := &aboutFetcher{client: &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{\"description\":\"about endpoint response\"}"))}}}
+	fetch(fetcher)
+	a.Equal(doc{Description: "about endpoint response"}, (<-about).Doc)
+
+	fetcher.client = &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{}, err: fmt.Errorf("timeout")}
+	fetch(fetcher)
+	a.EqualError(<-errors, "Could not get response from http://someService.billing/: (timeout)")
+	broken := <-about
+	a.Equal("Request failed: timeout", broken.Broken)
+	a.Equal(doc{Description: "about endpoint response"}, broken.Doc)
+}
+
 func TestFetcherErrorAddedToChannelForNonJSON(t *testing.T) {
 	a := assert.New(t)
 	errors := make(chan error, 10)
@@ -70,11 +94,36 @@ func TestFetcherErrorAddedToChannelForNonJSON(t *testing.T) {
 	fetcher := aboutFetcher{client: &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("non json"))}, err: nil}}
 	fetcher.readAbouts(services, about, errors)
 
+	assert.EqualError(t, <-errors, "Could not json decode __/about response for http://someService.billing/")
+	broken := <-about
+	assert.Equal(t, expectedService, broken.Service)
+	assert.Equal(t, "/__/about is not json", broken.Broken)
+	assert.Equal(t, doc{}, broken.Doc)
+	close(about)
+	close(errors)
+}
+
+func TestFetcherAboutWithSchemaViolationsAddedToChannel(t *testing.T) {
+	a := assert.New(t)
+	errors := make(chan error, 10)
+	services := make(chan service, 10)
+	about := make(chan about, 10)
+
+	expectedService := service{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/"}
+	services <- expectedService
+	close(services)
+
+	fetcher := aboutFetcher{client: &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{\"name\":\"someService\",\"tier\":\"1\"}"))}, err: nil}}
+	fetcher.readAbouts(services, about, errors)
+
 	select {
-	case err := <-errors:
-		assert.EqualError(t, err, "Could not json decode __/about response for http://someService.billing/")
-	case <-about:
-		t.Errorf("Should not get any about")
+	case <-errors:
+		t.Errorf("Should not get an error")
+
+	case about := <-about:
+		assert.Equal(t, expectedService, about.Service)
+		assert.Equal(t, doc{Name: "someService"}, about.Doc)
+		assert.Equal(t, []problem{{Kind: problemSchemaViolation, Message: "tier: Invalid type. Expected: integer, given: string"}}, about.Violations)
 	}
 	close(about)
 	close(errors)
@@ -93,16 +142,100 @@ func TestFetcherErrorAddedToChannelForNon200(t *testing.T) {
 	fetcher := aboutFetcher{client: &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader("about not found"))}, err: nil}}
 	fetcher.readAbouts(services, about, errors)
 
+	assert.EqualError(t, <-errors, "__/about returned 404 for http://someService.billing/")
+	broken := <-about
+	assert.Equal(t, expectedService, broken.Service)
+	assert.Equal(t, "/__/about returned 404", broken.Broken)
+	assert.Equal(t, doc{}, broken.Doc)
+	close(about)
+	close(errors)
+}
+
+func TestFetcherIgnoresUnlabelledServicesWithoutAbout(t *testing.T) {
+	a := assert.New(t)
+	errors := make(chan error, 10)
+	services := make(chan service, 10)
+	about := make(chan about, 10)
+
+	services <- service{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/", Unlabelled: true}
+	close(services)
+
+	fetcher := aboutFetcher{client: &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader("not found"))}, err: nil}}
+	fetcher.readAbouts(services, about, errors)
+
 	select {
-	case err := <-errors:
-		assert.EqualError(t, err, "__/about returned 404 for http://someService.billing/")
+	case <-errors:
+		t.Errorf("Should not get an error")
 	case <-about:
 		t.Errorf("Should not get any about")
+	case <-time.After(100 * time.Millisecond):
 	}
 	close(about)
 	close(errors)
 }
 
+func TestFetcherUnlabelledServiceWithAboutAddedToChannel(t *testing.T) {
+	a := assert.New(t)
+	errors := make(chan error, 10)
+	services := make(chan service, 10)
+	about := make(chan about, 10)
+
+	expectedService := service{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/", Unlabelled: true}
+	services <- expectedService
+	close(services)
+
+	fetcher := aboutFetcher{client: &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{\"description\":\"unlabelled\"}"))}, err: nil}}
+	fetcher.readAbouts(services, about, errors)
+
+	probed := <-about
+	assert.Equal(t, expectedService, probed.Service)
+	assert.Equal(t, doc{Description: "unlabelled"}, probed.Doc)
+	assert.Equal(t, "", probed.Broken)
+	close(about)
+	close(errors)
+}
+
+func TestFetcherReportsUnlabelledServiceThatStopsServingAbout(t *testing.T) {
+	a := assert.New(t)
+	errors := make(chan error, 10)
+	about := make(chan about, 10)
+	s := service{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/", Unlabelled: true}
+	fetch := func(fetcher *aboutFetcher) {
+		services := make(chan service, 1)
+		services <- s
+		close(services)
+		fetcher.readAbouts(services, about, errors)
+	}
+	ok := &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{\"description\":\"unlabelled\"}"))}}
+	notFound := &dummyClient{assert: a, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader("not found"))}}
+
+	fetcher := newAboutFetcher()
+	fetcher.client = ok
+	fetch(fetcher)
+	a.Equal(doc{Description: "unlabelled"}, (<-about).Doc)
+
+	fetcher.client = notFound
+	fetch(fetcher)
+	a.EqualError(<-errors, "__/about returned 404 for http://someService.billing/")
+	broken := <-about
+	a.Equal("/__/about returned 404", broken.Broken)
+	a.Equal(doc{Description: "unlabelled"}, broken.Doc)
+
+	removed, forwarded := make(chan service, 1), make(chan service, 1)
+	removed <- s
+	close(removed)
+	fetcher.forgetRemovals(removed, forwarded)
+	a.Equal(s, <-forwarded)
+	fetch(fetcher)
+	select {
+	case <-errors:
+		t.Errorf("Should not get an error")
+	case <-about:
+		t.Errorf("Should not get any about")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 type dummyClient struct {
 	assert *assert.Assertions
 	URL    string
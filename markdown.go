@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//go:embed templates/*.md
+var embeddedMarkdownTemplates embed.FS
+
+func loadMarkdownTemplates() (*template.Template, error) {
+	t, err := template.New("").Funcs(template.FuncMap{"slackURL": slackURL}).ParseFS(embeddedMarkdownTemplates, "templates/*.md")
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse markdown templates: (%v)", err)
+	}
+	return t, nil
+}
+
+// markdownExporter renders each service as a markdown file, with an index
+// per namespace, under path in a git working tree and commits every change.
+// With push, commits are pushed to the upstream of the checked out branch.
+// The indexes are written once the first discovery run is known, and that
+// of a namespace once every service it found there was handled, so that a
+// restart doesn't commit partial indexes.
+type markdownExporter struct {
+	dir       string
+	path      string
+	push      bool
+	author    string
+	email     string
+	templates *template.Template
+	mutex     sync.Mutex //protects abouts, primed, expected and the working tree
+	abouts    map[string]about
+	primed    bool
+	// expected are the services of the first discovery run not handled yet
+	expected map[string]service
+}
+
+func newMarkdownExporter(dir string, path string, push bool, author string, email string) (*markdownExporter, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+	templates, err := loadMarkdownTemplates()
+	if err != nil {
+		return nil, err
+	}
+	m := &markdownExporter{dir: dir, path: path, push: push, author: author, email: email, templates: templates, mutex: sync.Mutex{}, abouts: make(map[string]about), expected: make(map[string]service)}
+	if _, err := m.git("rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, fmt.Errorf("%v is not a git working tree: (%v)", dir, err)
+	}
+	return m, nil
+}
+
+func (m *markdownExporter) handle(about about) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := about.Service.key()
+	existing, ok := m.abouts[key]
+	if ok && existing.sameContent(about) {
+		return false, nil
+	}
+	expected, wasExpected := m.expected[key]
+	m.abouts[key] = about
+	delete(m.expected, key)
+	if err := m.export(about, existing, ok); err != nil {
+		// restore the state so that the next fetch of the service retries
+		if ok {
+			m.abouts[key] = existing
+		} else {
+			delete(m.abouts, key)
+		}
+		if wasExpected {
+			m.expected[key] = expected
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// export writes and commits the markdown of about, replacing existing if known.
+func (m *markdownExporter) export(about about, existing about, known bool) error {
+	key := about.Service.key()
+	var b bytes.Buffer
+	if err := m.templates.ExecuteTemplate(&b, "service.md", about); err != nil {
+		return fmt.Errorf("Couldn't render markdown for %v: (%v)", key, err)
+	}
+	path := m.servicePath(about.Service)
+	// after a restart the file of a service can be there already
+	_, statErr := os.Stat(path)
+	if err := writeFileAtomic(path, b.Bytes()); err != nil {
+		return err
+	}
+	if err := m.writeIndex(about.Service.Namespace); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Add %v", key)
+	if known {
+		message = fmt.Sprintf("Update %v", key)
+		if changed := changedFields(existing, about); len(changed) > 0 {
+			message += ": " + strings.Join(changed, ", ")
+		}
+	} else if statErr == nil {
+		message = fmt.Sprintf("Update %v", key)
+	}
+	return m.commit(message)
+}
+
+func (m *markdownExporter) remove(service service) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.expected, service.key())
+	if _, ok := m.abouts[service.key()]; !ok {
+		return false, nil
+	}
+	delete(m.abouts, service.key())
+	if err := os.Remove(m.servicePath(service)); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("Could not delete markdown of %v: (%v)", service.key(), err)
+	}
+	if err := m.writeIndex(service.Namespace); err != nil {
+		return false, err
+	}
+	err := m.commit(fmt.Sprintf("Remove %v", service.key()))
+	return err == nil, err
+}
+
+// prime deletes the markdown of the services that went away while the
+// aggregator was down, services being those found by the first discovery
+// run, and writes the indexes that are complete.
+func (m *markdownExporter) prime(services []service) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.primed = true
+	known := make(map[string]bool)
+	for _, s := range services {
+		known[s.key()] = true
+		// unlabelled services only have markdown if they have an about
+		if _, ok := m.abouts[s.key()]; !ok && !s.Unlabelled {
+			m.expected[s.key()] = s
+		}
+	}
+	namespaces, err := m.namespaces()
+	if err != nil {
+		return err
+	}
+	removed := []string{}
+	for _, namespace := range namespaces {
+		files, err := filepath.Glob(filepath.Join(m.dir, m.path, namespace, "*.md"))
+		if err != nil {
+			return fmt.Errorf("Could not list markdown of %v: (%v)", namespace, err)
+		}
+		for _, f := range files {
+			name := strings.TrimSuffix(filepath.Base(f), ".md")
+			s := service{Name: name, Namespace: namespace}
+			if name == "README" || known[s.key()] {
+				continue
+			}
+			if err := os.Remove(f); err != nil {
+				return fmt.Errorf("Could not delete markdown of %v: (%v)", s.key(), err)
+			}
+			removed = append(removed, s.key())
+		}
+	}
+	for _, a := range m.abouts {
+		if !contains(namespaces, a.Service.Namespace) {
+			namespaces = append(namespaces, a.Service.Namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil
+	}
+	for _, namespace := range namespaces {
+		if err := m.writeIndex(namespace); err != nil {
+			return err
+		}
+	}
+	message := "Update indexes"
+	if len(removed) > 0 {
+		message = fmt.Sprintf("Remove %v", strings.Join(removed, ", "))
+	}
+	return m.commit(message)
+}
+
+// namespaces returns the namespaces that have an index in the working tree,
+// telling them from other directories by the header of their README.md.
+// Callers must hold the mutex.
+func (m *markdownExporter) namespaces() ([]string, error) {
+	dirs, err := ioutil.ReadDir(filepath.Join(m.dir, m.path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Could not list markdown namespaces: (%v)", err)
+	}
+	namespaces := []string{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		index, err := ioutil.ReadFile(filepath.Join(m.dir, m.path, d.Name(), "README.md"))
+		if err != nil {
+			continue
+		}
+		header, err := m.renderIndex(d.Name(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(index, header) {
+			namespaces = append(namespaces, d.Name())
+		}
+	}
+	return namespaces, nil
+}
+
+func (m *markdownExporter) servicePath(s service) string {
+	return filepath.Join(m.dir, m.path, s.Namespace, s.Name+".md")
+}
+
+// writeIndex renders the README.md of a namespace, or deletes it when the
+// namespace has no services left. Nothing is written before every service
+// of the namespace found by the first discovery run is known. Callers must
+// hold the mutex.
+func (m *markdownExporter) writeIndex(namespace string) error {
+	if !m.primed {
+		return nil
+	}
+	for _, s := range m.expected {
+		if s.Namespace == namespace {
+			return nil
+		}
+	}
+	path := filepath.Join(m.dir, m.path, namespace, "README.md")
+	a := []about{}
+	for _, about := range m.abouts {
+		if about.Service.Namespace == namespace {
+			a = append(a, about)
+		}
+	}
+	if len(a) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Could not delete markdown index of %v: (%v)", namespace, err)
+		}
+		// fails, as it should, unless the directory is empty
+		os.Remove(filepath.Dir(path))
+		return nil
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Service.Name < a[j].Service.Name })
+	index, err := m.renderIndex(namespace, a)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, index)
+}
+
+func (m *markdownExporter) renderIndex(namespace string, abouts []about) ([]byte, error) {
+	var b bytes.Buffer
+	data := struct {
+		Namespace string
+		Abouts    []about
+	}{Namespace: namespace, Abouts: abouts}
+	if err := m.templates.ExecuteTemplate(&b, "namespace.md", data); err != nil {
+		return nil, fmt.Errorf("Couldn't render markdown index of %v: (%v)", namespace, err)
+	}
+	return b.Bytes(), nil
+}
+
+// commit commits the changes under path, if any, and pushes them. Callers
+// must hold the mutex.
+func (m *markdownExporter) commit(message string) error {
+	if _, err := m.git("add", "--all", "--", m.pathspec()); err != nil {
+		return err
+	}
+	status, err := m.git("status", "--porcelain", "--", m.pathspec())
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		if _, err := m.git("commit", "--quiet", "--message", message, "--", m.pathspec()); err != nil {
+			return err
+		}
+	}
+	// a commit left by a failed push is pushed with the next change
+	if !m.push {
+		return nil
+	}
+	if _, err := m.git("pull", "--rebase", "--quiet"); err != nil {
+		return err
+	}
+	_, err = m.git("push", "--quiet")
+	return err
+}
+
+func (m *markdownExporter) pathspec() string {
+	if m.path == "" {
+		return "."
+	}
+	return m.path
+}
+
+func (m *markdownExporter) git(command string, args ...string) (string, error) {
+	args = append([]string{"-C", m.dir, "-c", "user.name=" + m.author, "-c", "user.email=" + m.email, command}, args...)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %v failed: %v (%v)", command, strings.TrimSpace(string(out)), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// changedFields lists the top level about fields that differ between two
+// versions of a service, for commit messages.
+func changedFields(old about, updated about) []string {
+	fields := func(a about) map[string]json.RawMessage {
+		f := make(map[string]json.RawMessage)
+		b, _ := json.Marshal(a.Doc)
+		json.Unmarshal(b, &f)
+		if a.Broken != "" {
+			f["broken"], _ = json.Marshal(a.Broken)
+		}
+		return f
+	}
+	before, after := fields(old), fields(updated)
+	changed := []string{}
+	for k, v := range after {
+		if !bytes.Equal(before[k], v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
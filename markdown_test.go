@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runGit(t *testing.T, args ...string) string {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newTestMarkdownRepo creates a bare repo with one commit and a clone of it
+// to export to.
+func newTestMarkdownRepo(t *testing.T) (bare string, clone string) {
+	dir := tempDir(t)
+	bare, clone = filepath.Join(dir, "handbook.git"), filepath.Join(dir, "handbook")
+	runGit(t, "init", "--quiet", "--bare", bare)
+	runGit(t, "clone", "--quiet", bare, clone)
+	ioutil.WriteFile(filepath.Join(clone, "README.md"), []byte("# Handbook\n"), 0644)
+	runGit(t, "-C", clone, "add", "README.md")
+	runGit(t, "-C", clone, "-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "--quiet", "-m", "Initial commit")
+	runGit(t, "-C", clone, "push", "--quiet", "origin", "HEAD")
+	runGit(t, "-C", clone, "branch", "--quiet", "--set-upstream-to", "origin/"+runGit(t, "-C", clone, "rev-parse", "--abbrev-ref", "HEAD"))
+	return bare, clone
+}
+
+func TestMarkdownExporterCommitsChanges(t *testing.T) {
+	assert := assert.New(t)
+	bare, clone := newTestMarkdownRepo(t)
+	m, err := newMarkdownExporter(clone, "services", true, "aggregator", "aggregator@localhost")
+	assert.NoError(err)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{
+		Description:  "Reference data",
+		Owners:       []owner{{Name: "Billing", Slack: "#billing"}},
+		Links:        []link{{URL: "https://wiki/refdata", Description: "Wiki"}},
+		Dependencies: []dependency{{Name: "billing-db", Type: "database"}},
+		BuildInfo:    buildInfo{Revision: "abc123"},
+		Tier:         1,
+	}}
+	updated := refdata
+	updated.Doc.BuildInfo = buildInfo{Revision: "def456", Version: "1.1.0"}
+	updated.Doc.Tier = 2
+	invoices := about{Service: service{Name: "uw-service-invoices", Namespace: "billing"}}
+
+	assert.NoError(m.prime(nil))
+	assert.Equal("published", outcome(m.handle(refdata)))
+	assert.Equal("nothing published", outcome(m.handle(refdata)))
+	assert.Equal("published", outcome(m.handle(invoices)))
+	assert.Equal("published", outcome(m.handle(updated)))
+	assert.Equal("published", outcome(m.remove(invoices.Service)))
+
+	assert.Equal("Remove billing/uw-service-invoices\nUpdate billing/uw-service-refdata: build-info, tier\nAdd billing/uw-service-invoices\nAdd billing/uw-service-refdata\nInitial commit",
+		runGit(t, "--git-dir", bare, "log", "--format=%s"))
+	assert.Equal("aggregator <aggregator@localhost>", runGit(t, "--git-dir", bare, "log", "-1", "--format=%an <%ae>"))
+
+	page := runGit(t, "--git-dir", bare, "show", "HEAD:services/billing/uw-service-refdata.md")
+	assert.Contains(page, "# billing.uw-service-refdata\n\nReference data\n\n- **Tier:** 2\n")
+	assert.Contains(page, "- Billing ([#billing](https://slack.com/app_redirect?channel=billing))")
+	assert.Contains(page, "- [Wiki](https://wiki/refdata)")
+	assert.Contains(page, "- `billing-db` (database)")
+	assert.Contains(page, "- **Revision:** `def456`\n- **Version:** 1.1.0")
+	assert.Equal("# billing\n\nServices of the billing namespace, as documented by their `/__/about`.\n\n- [uw-service-refdata](uw-service-refdata.md) - Reference data (owners: Billing)",
+		runGit(t, "--git-dir", bare, "show", "HEAD:services/billing/README.md"))
+	assert.Equal("README.md\nservices/billing/README.md\nservices/billing/uw-service-refdata.md", runGit(t, "--git-dir", bare, "ls-tree", "-r", "--name-only", "HEAD"))
+}
+
+func TestMarkdownExporterSkipsUnchangedFilesAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+	_, clone := newTestMarkdownRepo(t)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "Reference data"}}
+	m, _ := newMarkdownExporter(clone, "services", false, "aggregator", "aggregator@localhost")
+	assert.NoError(m.prime([]service{refdata.Service}))
+	assert.Equal("published", outcome(m.handle(refdata)))
+
+	m, _ = newMarkdownExporter(clone, "services", false, "aggregator", "aggregator@localhost")
+	assert.NoError(m.prime([]service{refdata.Service}))
+	assert.Equal("published", outcome(m.handle(refdata)))
+	refdata.Doc.Description = "Tariffs"
+	m, _ = newMarkdownExporter(clone, "services", false, "aggregator", "aggregator@localhost")
+	assert.NoError(m.prime([]service{refdata.Service}))
+	assert.Equal("published", outcome(m.handle(refdata)))
+
+	assert.Equal("Update billing/uw-service-refdata\nAdd billing/uw-service-refdata\nInitial commit", runGit(t, "-C", clone, "log", "--format=%s"))
+}
+
+func TestMarkdownExporterRebuildsIndexesAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+	_, clone := newTestMarkdownRepo(t)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "Reference data"}}
+	invoices := about{Service: service{Name: "uw-service-invoices", Namespace: "billing"}, Doc: doc{Description: "Invoices"}}
+	ledger := about{Service: service{Name: "uw-service-ledger", Namespace: "billing"}, Doc: doc{Description: "Ledger"}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+	m, _ := newMarkdownExporter(clone, "services", false, "aggregator", "aggregator@localhost")
+	assert.NoError(m.prime([]service{refdata.Service, invoices.Service, ledger.Service, crm.Service}))
+	for _, a := range []about{refdata, invoices, ledger, crm} {
+		assert.Equal("published", outcome(m.handle(a)))
+	}
+
+	// ledger and crm went away while the aggregator was down
+	m, _ = newMarkdownExporter(clone, "services", false, "aggregator", "aggregator@localhost")
+	assert.Equal("published", outcome(m.handle(refdata)))
+	index, _ := ioutil.ReadFile(filepath.Join(clone, "services", "billing", "README.md"))
+	assert.Contains(string(index), "uw-service-ledger", "indexes aren't written before the first discovery run")
+	assert.NoError(m.prime([]service{refdata.Service, invoices.Service}))
+	assert.Equal("Remove billing/uw-service-ledger, crm/uw-service-crm", runGit(t, "-C", clone, "log", "-1", "--format=%s"))
+	index, _ = ioutil.ReadFile(filepath.Join(clone, "services", "billing", "README.md"))
+	assert.Contains(string(index), "uw-service-ledger", "the index waits for invoices")
+
+	invoices.Doc.Description = "Invoicing"
+	assert.Equal("published", outcome(m.handle(invoices)))
+	index, _ = ioutil.ReadFile(filepath.Join(clone, "services", "billing", "README.md"))
+	assert.Equal("# billing\n\nServices of the billing namespace, as documented by their `/__/about`.\n\n- [uw-service-invoices](uw-service-invoices.md) - Invoicing\n- [uw-service-refdata](uw-service-refdata.md) - Reference data\n", string(index))
+	assert.Equal("README.md\nservices/billing/README.md\nservices/billing/uw-service-invoices.md\nservices/billing/uw-service-refdata.md", runGit(t, "-C", clone, "ls-tree", "-r", "--name-only", "HEAD"))
+}
+
+func TestMarkdownExporterRetriesFailedPush(t *testing.T) {
+	assert := assert.New(t)
+	bare, clone := newTestMarkdownRepo(t)
+	m, _ := newMarkdownExporter(clone, "services", true, "aggregator", "aggregator@localhost")
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "Reference data"}}
+	assert.NoError(m.prime(nil))
+
+	assert.NoError(os.Rename(bare, bare+".moved"))
+	published, err := m.handle(refdata)
+	assert.False(published)
+	assert.Error(err)
+	assert.NoError(os.Rename(bare+".moved", bare))
+	assert.Equal("published", outcome(m.handle(refdata)))
+
+	assert.Equal("Add billing/uw-service-refdata\nInitial commit", runGit(t, "--git-dir", bare, "log", "--format=%s"))
+}
+
+func TestMarkdownExporterBrokenService(t *testing.T) {
+	assert := assert.New(t)
+	_, clone := newTestMarkdownRepo(t)
+	m, _ := newMarkdownExporter(clone, "", false, "aggregator", "aggregator@localhost")
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "Reference data"}}
+
+	assert.Equal("published", outcome(m.handle(refdata)))
+	assert.Equal("published", outcome(m.handle(about{Service: refdata.Service, Broken: "/__/about returned 500"})))
+
+	assert.Equal("Update billing/uw-service-refdata: broken, description", runGit(t, "-C", clone, "log", "-1", "--format=%s"))
+	b, _ := ioutil.ReadFile(filepath.Join(clone, "billing", "uw-service-refdata.md"))
+	assert.Contains(string(b), "> **Broken:** /__/about returned 500")
+}
+
+func TestNewMarkdownExporterErrors(t *testing.T) {
+	assert := assert.New(t)
+	_, err := newMarkdownExporter("", "services", false, "aggregator", "aggregator@localhost")
+	assert.EqualError(err, "dir is required")
+	_, err = newMarkdownExporter(tempDir(t), "services", false, "aggregator", "aggregator@localhost")
+	assert.Error(err)
+}
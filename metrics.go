@@ -0,0 +1,145 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "about_aggregator"
+
+var (
+	discoveryRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "discovery_runs_total",
+		Help:      "Service discovery runs by result.",
+	}, []string{"result"})
+	discoveryDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "discovery_duration_seconds",
+		Help:      "Time taken by a service discovery run.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	discoveredServices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "discovered_services",
+		Help:      "Services found by the last complete discovery run, by namespace and how they were found.",
+	}, []string{"namespace", "discovery"})
+	fetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetches_total",
+		Help:      "Calls to /__/about by result, ok or the reason they failed, and how the service was found.",
+	}, []string{"result", "discovery"})
+	fetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Time taken to fetch and validate a /__/about.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	exportsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "exports_in_flight",
+		Help:      "Abouts and removals handed to an exporter and not handled yet.",
+	}, []string{"exporter"})
+	exportErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "export_errors_total",
+		Help:      "Errors returned by exporters.",
+	}, []string{"exporter"})
+	confluenceRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "confluence_request_duration_seconds",
+		Help:      "Latency of the confluence api by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	exporterLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "exporter_last_success_timestamp_seconds",
+		Help:      "When an exporter last published successfully.",
+	}, []string{"exporter"})
+	exporterConsecutiveFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "exporter_consecutive_failures",
+		Help:      "Failures of an exporter since it last published successfully.",
+	}, []string{"exporter"})
+	exporterQueueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "exporter_queue_length",
+		Help:      "Changes waiting to be published by an exporter publishing in the background.",
+	}, []string{"exporter"})
+	droppedErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dropped_errors_total",
+		Help:      "Errors not logged because the errors channel was full.",
+	})
+)
+
+// results of fetching a /__/about, used as the result label of fetches_total.
+const (
+	fetchOK            = "ok"
+	fetchRequestFailed = "request-failed"
+	fetchBadStatus     = "bad-status"
+	fetchReadFailed    = "read-failed"
+	fetchInvalidJSON   = "invalid-json"
+	fetchNotValidated  = "not-validated"
+)
+
+const (
+	discoveryRunSuccess = "success"
+	discoveryRunError   = "error"
+)
+
+// how a service was found, used as the discovery label so that probing
+// unlabelled services doesn't swamp the metrics of the labelled ones.
+const (
+	discoveryLabel = "label"
+	discoveryProbe = "probe"
+)
+
+func discoveredBy(s service) string {
+	if s.Unlabelled {
+		return discoveryProbe
+	}
+	return discoveryLabel
+}
+
+func init() {
+	prometheus.MustRegister(discoveryRuns, discoveryDuration, discoveredServices, fetches, fetchDuration, exportsInFlight, exportErrors, confluenceRequestDuration, exporterLastSuccess, exporterConsecutiveFailures, exporterQueueLength, droppedErrors)
+}
+
+// registerQueueMetrics reports how many items wait in each channel of the
+// pipeline.
+func registerQueueMetrics(services chan service, abouts chan about, removed chan service, errors chan error) {
+	queues := map[string]func() int{
+		"services": func() int { return len(services) },
+		"abouts":   func() int { return len(abouts) },
+		"removed":  func() int { return len(removed) },
+		"errors":   func() int { return len(errors) },
+	}
+	for name, length := range queues {
+		length := length
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "queue_length",
+			Help:        "Items waiting in a channel of the aggregator pipeline.",
+			ConstLabels: prometheus.Labels{"queue": name},
+		}, func() float64 { return float64(length()) }))
+	}
+}
+
+// reportError sends err to errors unless it is full, in which case err is
+// dropped and counted.
+func reportError(errors chan<- error, err error) {
+	select {
+	case errors <- err:
+	default:
+		droppedErrors.Inc()
+	}
+}
+
+// exporterName names an exporter after its type, e.g. confluence for
+// confluenceExporter.
+func exporterName(e exporter) string {
+	return strings.TrimSuffix(reflect.Indirect(reflect.ValueOf(e)).Type().Name(), "Exporter")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingExporter struct{}
+
+func (f *failingExporter) handle(about about) (bool, error) {
+	return false, fmt.Errorf("failed")
+}
+
+func (f *failingExporter) remove(service service) (bool, error) {
+	return false, fmt.Errorf("failed")
+}
+
+func TestExporterName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("http", exporterName(newHTTPExporter(testTemplates, testScorer, testPolicies, newExporterHealth(3))))
+	assert.Equal("event", exporterName(newEventExporter(1)))
+	assert.Equal("failing", exporterName(&failingExporter{}))
+}
+
+func TestReportErrorCountsDroppedErrors(t *testing.T) {
+	assert := assert.New(t)
+	errors := make(chan error, 1)
+	before := testutil.ToFloat64(droppedErrors)
+
+	reportError(errors, fmt.Errorf("first"))
+	reportError(errors, fmt.Errorf("second"))
+
+	assert.EqualError(<-errors, "first")
+	assert.Equal(before+1, testutil.ToFloat64(droppedErrors))
+}
+
+func TestExportErrorsCounted(t *testing.T) {
+	assert := assert.New(t)
+	errors := make(chan error, 10)
+	ab := make(chan about, 10)
+	e := exporterService{exporters: []exporter{&failingExporter{}}, health: newExporterHealth(3)}
+	before := testutil.ToFloat64(exportErrors.WithLabelValues("failing"))
+	ab <- about{}
+	close(ab)
+
+	e.export(ab, errors)
+
+	assert.EqualError(<-errors, "Error while exporting: (failed)")
+	assert.Equal(before+1, testutil.ToFloat64(exportErrors.WithLabelValues("failing")))
+}
+
+func TestFetchesCountedByResult(t *testing.T) {
+	assert := assert.New(t)
+	s := service{Name: "someService", Namespace: "billing", BaseURL: "http://someService.billing/"}
+	ok := testutil.ToFloat64(fetches.WithLabelValues(fetchOK, discoveryLabel))
+	badStatus := testutil.ToFloat64(fetches.WithLabelValues(fetchBadStatus, discoveryLabel))
+	probed := testutil.ToFloat64(fetches.WithLabelValues(fetchBadStatus, discoveryProbe))
+
+	fetcher := aboutFetcher{client: &dummyClient{assert: assert, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{}"))}}}
+	fetcher.fetch(s)
+	fetcher = aboutFetcher{client: &dummyClient{assert: assert, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(""))}}}
+	fetcher.fetch(s)
+	s.Unlabelled = true
+	fetcher = aboutFetcher{client: &dummyClient{assert: assert, URL: "http://someService.billing/__/about", resp: http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(""))}}}
+	fetcher.fetch(s)
+
+	assert.Equal(ok+1, testutil.ToFloat64(fetches.WithLabelValues(fetchOK, discoveryLabel)))
+	assert.Equal(badStatus+1, testutil.ToFloat64(fetches.WithLabelValues(fetchBadStatus, discoveryLabel)))
+	assert.Equal(probed+1, testutil.ToFloat64(fetches.WithLabelValues(fetchBadStatus, discoveryProbe)))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	assert := assert.New(t)
+	fetches.WithLabelValues(fetchOK, discoveryLabel).Inc()
+
+	rec := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rec, newRequest("GET", "/metrics", "text/plain", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), "about_aggregator_fetches_total{discovery=\"label\",result=\"ok\"}")
+	assert.Contains(rec.Body.String(), "about_aggregator_dropped_errors_total")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// team is an owner as named in the owners of one or more abouts. Owners are
+// matched case-insensitively, keeping the first spelling seen.
+type team struct {
+	Name     string    `json:"name"`
+	Slack    []string  `json:"slack"`
+	Services []service `json:"services"`
+}
+
+type ownerIndex struct {
+	Owners  []team    `json:"owners"`
+	Unowned []service `json:"unowned"`
+}
+
+// newOwnerIndex inverts abouts into the teams owning them. abouts are
+// expected to be sorted, which keeps the services of each team sorted too.
+func newOwnerIndex(abouts []about) ownerIndex {
+	teams := make(map[string]*team)
+	index := ownerIndex{Owners: []team{}, Unowned: []service{}}
+	for _, a := range abouts {
+		owned := false
+		for _, o := range a.Doc.Owners {
+			name := strings.TrimSpace(o.Name)
+			if name == "" {
+				continue
+			}
+			owned = true
+			t, ok := teams[strings.ToLower(name)]
+			if !ok {
+				t = &team{Name: name, Slack: []string{}, Services: []service{}}
+				teams[strings.ToLower(name)] = t
+			}
+			if o.Slack != "" && !contains(t.Slack, o.Slack) {
+				t.Slack = append(t.Slack, o.Slack)
+			}
+			if len(t.Services) == 0 || t.Services[len(t.Services)-1] != a.Service {
+				t.Services = append(t.Services, a.Service)
+			}
+		}
+		if !owned {
+			index.Unowned = append(index.Unowned, a.Service)
+		}
+	}
+	for _, t := range teams {
+		sort.Strings(t.Slack)
+		index.Owners = append(index.Owners, *t)
+	}
+	sort.Slice(index.Owners, func(i, j int) bool {
+		return strings.ToLower(index.Owners[i].Name) < strings.ToLower(index.Owners[j].Name)
+	})
+	return index
+}
+
+// owner returns the team with the given name, ignoring case.
+func (o ownerIndex) owner(name string) (team, bool) {
+	for _, t := range o.Owners {
+		if strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return team{}, false
+}
+
+func (h *httpExporter) handleOwnersHTTP(w http.ResponseWriter, r *http.Request) {
+	a, lastModified := h.list()
+	index := newOwnerIndex(a)
+	if r.Header.Get("Accept") == "application/json" {
+		writeJSON(w, r, index, lastModified)
+	} else {
+		h.writeHTML(w, r, "owners.html", index, lastModified)
+	}
+}
+
+func (h *httpExporter) handleOwnerHTTP(w http.ResponseWriter, r *http.Request) {
+	a, lastModified := h.list()
+	t, ok := newOwnerIndex(a).owner(mux.Vars(r)["name"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Owner not found"))
+		return
+	}
+	if r.Header.Get("Accept") == "application/json" {
+		writeJSON(w, r, t, lastModified)
+	} else {
+		h.writeHTML(w, r, "owners.html", ownerIndex{Owners: []team{t}}, lastModified)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
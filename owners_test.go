@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOwnerIndex(t *testing.T) {
+	assert := assert.New(t)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing", Slack: "#billing"}, {Name: "Platform", Slack: "#infra"}}}}
+	account := about{Service: service{Name: "uw-service-account", Namespace: "crm"}, Doc: doc{Owners: []owner{{Name: "billing", Slack: "#billing-alerts"}, {Name: "Billing", Slack: "#billing"}}}}
+	orphan := about{Service: service{Name: "uw-service-orphan", Namespace: "crm"}, Doc: doc{Owners: []owner{{Name: " ", Slack: "#nobody"}}}}
+
+	assert.Equal(ownerIndex{
+		Owners: []team{
+			{Name: "Billing", Slack: []string{"#billing", "#billing-alerts"}, Services: []service{refdata.Service, account.Service}},
+			{Name: "Platform", Slack: []string{"#infra"}, Services: []service{refdata.Service}},
+		},
+		Unowned: []service{orphan.Service},
+	}, newOwnerIndex([]about{refdata, account, orphan}))
+}
+
+func TestOwnerHandlers(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing", Slack: "#billing"}}}})
+	e.handle(about{Service: service{Name: "uw-service-orphan", Namespace: "crm"}})
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		statusCode int
+		contains   string
+	}{
+		{"Owners json", newRequest("GET", "/__/owners", "application/json", nil), http.StatusOK, "{\"owners\":[{\"name\":\"Billing\",\"slack\":[\"#billing\"],\"services\":[{\"Name\":\"uw-service-refdata\",\"Namespace\":\"billing\",\"BaseURL\":\"\"}]}],\"unowned\":[{\"Name\":\"uw-service-orphan\",\"Namespace\":\"crm\",\"BaseURL\":\"\"}]}"},
+		{"Owners html", newRequest("GET", "/__/owners", "text/html", nil), http.StatusOK, "<li><a href=\"/__/about/crm/uw-service-orphan\">crm.uw-service-orphan</a></li>"},
+		{"Owner json", newRequest("GET", "/__/owners/billing", "application/json", nil), http.StatusOK, "{\"name\":\"Billing\",\"slack\":[\"#billing\"]"},
+		{"Owner html", newRequest("GET", "/__/owners/Billing", "text/html", nil), http.StatusOK, "<h2><a href=\"/__/owners/Billing\">Billing</a>"},
+		{"Unknown owner", newRequest("GET", "/__/owners/nobody", "application/json", nil), http.StatusNotFound, "Owner not found"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router(e).ServeHTTP(rec, test.req)
+		assert.Equal(test.statusCode, rec.Code, test.name)
+		assert.Contains(rec.Body.String(), test.contains, test.name)
+	}
+}
+
+func TestOwnerHandlerEscapesSlashes(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-payments", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing/Payments"}}}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about/billing/uw-service-payments", "text/html", nil))
+	assert.Contains(rec.Body.String(), "<a href=\"/__/owners/Billing%2FPayments\">Billing/Payments</a>")
+
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/owners/Billing%2FPayments", "text/html", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), "<h2><a href=\"/__/owners/Billing%2FPayments\">Billing/Payments</a>")
+}
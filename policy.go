@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const problemPolicyViolation = "policy-violation"
+
+// policy requires the services it selects to document at least Min of the
+// Require field. Namespace, Tier and Lifecycle select services, an empty
+// selector matches every service.
+type policy struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Tier        int    `json:"tier,omitempty"`
+	Lifecycle   string `json:"lifecycle,omitempty"`
+	Require     string `json:"require"`
+	// Min is how many of Require are needed, 1 if not set.
+	Min int `json:"min,omitempty"`
+}
+
+// policyFields count how many of a field a doc has, 0 or 1 for single
+// values.
+var policyFields = map[string]func(d doc) int{
+	"description": func(d doc) int { return present(d.Description) },
+	"owners":      func(d doc) int { return len(d.Owners) },
+	"owners-slack": func(d doc) int {
+		n := 0
+		for _, o := range d.Owners {
+			n += present(o.Slack)
+		}
+		return n
+	},
+	"links":        func(d doc) int { return len(d.Links) },
+	"dependencies": func(d doc) int { return len(d.Dependencies) },
+	"tags":         func(d doc) int { return len(d.Tags) },
+	"runbook":      func(d doc) int { return present(d.Runbook) },
+	"on-call":      func(d doc) int { return present(d.OnCall) },
+	"repository":   func(d doc) int { return present(d.Repository) },
+	"lifecycle":    func(d doc) int { return present(d.Lifecycle) },
+	"tier": func(d doc) int {
+		if d.Tier == 0 {
+			return 0
+		}
+		return 1
+	},
+	"version":    func(d doc) int { return present(d.BuildInfo.Version) },
+	"build-time": func(d doc) int { return present(d.BuildInfo.BuildTime) },
+}
+
+func present(s string) int {
+	if s == "" {
+		return 0
+	}
+	return 1
+}
+
+func (p policy) applies(a about) bool {
+	return (p.Namespace == "" || p.Namespace == a.Service.Namespace) &&
+		(p.Tier == 0 || p.Tier == a.Doc.Tier) &&
+		(p.Lifecycle == "" || p.Lifecycle == a.Doc.Lifecycle)
+}
+
+// check returns the violation of p by a, if any.
+func (p policy) check(a about) (problem, bool) {
+	found := policyFields[p.Require](a.Doc)
+	if found >= p.Min {
+		return problem{}, true
+	}
+	return problem{Kind: problemPolicyViolation, Message: fmt.Sprintf("Policy %v requires at least %d %v, found %d", p.Name, p.Min, p.Require, found)}, false
+}
+
+type policyChecker struct {
+	policies []policy
+}
+
+func newPolicyChecker(policies []policy) (*policyChecker, error) {
+	parsed := []policy{}
+	names := make(map[string]bool)
+	for _, p := range policies {
+		if p.Name == "" {
+			return nil, fmt.Errorf("Policies must have a name")
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("Policy name %v is used more than once", p.Name)
+		}
+		names[p.Name] = true
+		if _, ok := policyFields[p.Require]; !ok {
+			return nil, fmt.Errorf("Policy %v requires unknown field %q", p.Name, p.Require)
+		}
+		if p.Min < 0 {
+			return nil, fmt.Errorf("Policy %v must have a positive min", p.Name)
+		}
+		if p.Min == 0 {
+			p.Min = 1
+		}
+		parsed = append(parsed, p)
+	}
+	return &policyChecker{policies: parsed}, nil
+}
+
+// loadPolicies reads policies from a json file. Without a file there are no
+// policies and every service complies.
+func loadPolicies(path string) (*policyChecker, error) {
+	if path == "" {
+		return newPolicyChecker(nil)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read policies: (%v)", err)
+	}
+	var policies []policy
+	if err := json.Unmarshal(b, &policies); err != nil {
+		return nil, fmt.Errorf("Could not json decode policies in %v: (%v)", path, err)
+	}
+	return newPolicyChecker(policies)
+}
+
+// policySummary is how many services a policy applies to and how many of
+// them violate it.
+type policySummary struct {
+	Policy   policy `json:"policy"`
+	Services int    `json:"services"`
+	Failing  int    `json:"failing"`
+}
+
+type policyReport struct {
+	Policies []policySummary `json:"policies"`
+	// Services lists the services violating at least one policy.
+	Services []serviceStatus `json:"services"`
+}
+
+func (c *policyChecker) report(abouts []about) policyReport {
+	r := policyReport{Policies: []policySummary{}, Services: []serviceStatus{}}
+	for _, p := range c.policies {
+		r.Policies = append(r.Policies, policySummary{Policy: p})
+	}
+	for _, a := range abouts {
+		violations := []problem{}
+		for i, p := range c.policies {
+			if !p.applies(a) {
+				continue
+			}
+			r.Policies[i].Services++
+			if v, ok := p.check(a); !ok {
+				r.Policies[i].Failing++
+				violations = append(violations, v)
+			}
+		}
+		if len(violations) > 0 {
+			r.Services = append(r.Services, serviceStatus{Service: a.Service, Problems: violations})
+		}
+	}
+	return r
+}
+
+func (h *httpExporter) handlePoliciesHTTP(w http.ResponseWriter, r *http.Request) {
+	a, lastModified := h.list()
+	writeJSON(w, r, h.policies.report(a), lastModified)
+}
+
+// checkPolicies evaluates the policies against the catalogue of a running
+// aggregator, writes the violations to out and returns how many services
+// violate a policy.
+func checkPolicies(client httpClient, aggregatorURL string, c *policyChecker, out io.Writer) (int, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(aggregatorURL, "/")+"/__/about", nil)
+	if err != nil {
+		return 0, fmt.Errorf("Could not create request for %v: (%v)", aggregatorURL, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Could not get response from %v: (%v)", req.URL.String(), err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%v returned status %d", req.URL.String(), resp.StatusCode)
+	}
+	var abouts []about
+	if err := json.NewDecoder(resp.Body).Decode(&abouts); err != nil {
+		return 0, fmt.Errorf("Could not json decode catalogue from %v: (%v)", req.URL.String(), err)
+	}
+	r := c.report(abouts)
+	for _, s := range r.Services {
+		for _, p := range s.Problems {
+			fmt.Fprintf(out, "%v: %v\n", s.Service.key(), p.Message)
+		}
+	}
+	fmt.Fprintf(out, "%d of %d services violate a policy\n", len(r.Services), len(abouts))
+	return len(r.Services), nil
+}
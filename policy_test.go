@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPolicies = func() *policyChecker {
+	c, err := newPolicyChecker([]policy{
+		{Name: "billing-runbook", Namespace: "billing", Require: "runbook"},
+		{Name: "tier-1-owners", Tier: 1, Require: "owners", Min: 2},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+func TestPolicyReport(t *testing.T) {
+	assert := assert.New(t)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Tier: 1, Owners: []owner{{Name: "Billing"}}}}
+	invoices := about{Service: service{Name: "uw-service-invoices", Namespace: "billing"}, Doc: doc{Tier: 2, Runbook: "https://wiki/invoices"}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}, Doc: doc{Tier: 1, Owners: []owner{{Name: "CRM"}, {Name: "Platform"}}}}
+
+	assert.Equal(policyReport{
+		Policies: []policySummary{
+			{Policy: policy{Name: "billing-runbook", Namespace: "billing", Require: "runbook", Min: 1}, Services: 2, Failing: 1},
+			{Policy: policy{Name: "tier-1-owners", Tier: 1, Require: "owners", Min: 2}, Services: 2, Failing: 1},
+		},
+		Services: []serviceStatus{{Service: refdata.Service, Problems: []problem{
+			{Kind: problemPolicyViolation, Message: "Policy billing-runbook requires at least 1 runbook, found 0"},
+			{Kind: problemPolicyViolation, Message: "Policy tier-1-owners requires at least 2 owners, found 1"},
+		}}},
+	}, testPolicies.report([]about{refdata, invoices, crm}))
+}
+
+func TestPolicySelectors(t *testing.T) {
+	assert := assert.New(t)
+	var tests = []struct {
+		policy  policy
+		about   about
+		applies bool
+	}{
+		{policy{}, about{}, true},
+		{policy{Namespace: "billing"}, about{Service: service{Namespace: "billing"}}, true},
+		{policy{Namespace: "billing"}, about{Service: service{Namespace: "crm"}}, false},
+		{policy{Tier: 1}, about{Doc: doc{Tier: 2}}, false},
+		{policy{Lifecycle: lifecycleProduction}, about{Doc: doc{Lifecycle: lifecycleProduction}}, true},
+		{policy{Namespace: "billing", Lifecycle: lifecycleProduction}, about{Service: service{Namespace: "billing"}, Doc: doc{Lifecycle: lifecycleDeprecated}}, false},
+	}
+	for _, test := range tests {
+		assert.Equal(test.applies, test.policy.applies(test.about), "%+v", test.policy)
+	}
+}
+
+func TestNewPolicyCheckerErrors(t *testing.T) {
+	assert := assert.New(t)
+	_, err := newPolicyChecker([]policy{{Require: "runbook"}})
+	assert.EqualError(err, "Policies must have a name")
+	_, err = newPolicyChecker([]policy{{Name: "a", Require: "runbook"}, {Name: "a", Require: "owners"}})
+	assert.EqualError(err, "Policy name a is used more than once")
+	_, err = newPolicyChecker([]policy{{Name: "a", Require: "readme"}})
+	assert.EqualError(err, "Policy a requires unknown field \"readme\"")
+	_, err = newPolicyChecker([]policy{{Name: "a", Require: "owners", Min: -1}})
+	assert.EqualError(err, "Policy a must have a positive min")
+}
+
+func TestLoadPolicies(t *testing.T) {
+	assert := assert.New(t)
+	c, err := loadPolicies("")
+	assert.NoError(err)
+	assert.Empty(c.policies)
+
+	path := filepath.Join(tempDir(t), "policies.json")
+	ioutil.WriteFile(path, []byte(`[{"name":"slack","require":"owners-slack"}]`), 0644)
+	c, err = loadPolicies(path)
+	assert.NoError(err)
+	assert.Equal([]policy{{Name: "slack", Require: "owners-slack", Min: 1}}, c.policies)
+
+	ioutil.WriteFile(path, []byte(`{"name":"slack"}`), 0644)
+	_, err = loadPolicies(path)
+	assert.Error(err)
+}
+
+func TestPoliciesHandler(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/policies", "application/json", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `"services":[{"service":{"Name":"uw-service-refdata","Namespace":"billing","BaseURL":""},"problems":[{"kind":"policy-violation","message":"Policy billing-runbook requires at least 1 runbook, found 0"}]}]`)
+}
+
+func TestCheckPolicies(t *testing.T) {
+	assert := assert.New(t)
+	catalogue := `[{"Service":{"Name":"uw-service-refdata","Namespace":"billing"},"Doc":{"owners":[{"name":"Billing"}]}},{"Service":{"Name":"uw-service-crm","Namespace":"crm"},"Doc":{}}]`
+	client := &dummyClient{assert: assert, URL: "http://aggregator/__/about", resp: http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(catalogue))}}
+
+	var out bytes.Buffer
+	failing, err := checkPolicies(client, "http://aggregator/", testPolicies, &out)
+	assert.NoError(err)
+	assert.Equal(1, failing)
+	assert.Equal("billing/uw-service-refdata: Policy billing-runbook requires at least 1 runbook, found 0\n1 of 2 services violate a policy\n", out.String())
+}
+
+func TestCheckPoliciesErrors(t *testing.T) {
+	assert := assert.New(t)
+	client := &dummyClient{assert: assert, URL: "http://aggregator/__/about", err: fmt.Errorf("connection refused")}
+	_, err := checkPolicies(client, "http://aggregator", testPolicies, ioutil.Discard)
+	assert.EqualError(err, "Could not get response from http://aggregator/__/about: (connection refused)")
+
+	client = &dummyClient{assert: assert, URL: "http://aggregator/__/about", resp: http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(strings.NewReader(""))}}
+	_, err = checkPolicies(client, "http://aggregator", testPolicies, ioutil.Discard)
+	assert.EqualError(err, "http://aggregator/__/about returned status 503")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// prometheusExporter publishes the metadata of each service as an info
+// metric, one series per owner, so that alerts and dashboards can be joined
+// with ownership.
+type prometheusExporter struct {
+	info   *prometheus.GaugeVec
+	mutex  sync.Mutex //protects series
+	series map[string][]prometheus.Labels
+}
+
+func newPrometheusExporter(registerer prometheus.Registerer) (*prometheusExporter, error) {
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "service_about_info",
+		Help: "Metadata of the services from their /__/about, always 1.",
+	}, []string{"namespace", "name", "owner", "slack", "revision", "version", "tier", "lifecycle"})
+	if err := registerer.Register(info); err != nil {
+		return nil, fmt.Errorf("Could not register service_about_info: (%v)", err)
+	}
+	return &prometheusExporter{info: info, mutex: sync.Mutex{}, series: make(map[string][]prometheus.Labels)}, nil
+}
+
+func (p *prometheusExporter) handle(about about) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := about.Service.key()
+	p.deleteSeries(key)
+	owners := about.Doc.Owners
+	if len(owners) == 0 {
+		owners = []owner{{}}
+	}
+	tier := ""
+	if about.Doc.Tier != 0 {
+		tier = strconv.Itoa(about.Doc.Tier)
+	}
+	for _, o := range owners {
+		labels := prometheus.Labels{
+			"namespace": about.Service.Namespace,
+			"name":      about.Service.Name,
+			"owner":     o.Name,
+			"slack":     o.Slack,
+			"revision":  about.Doc.BuildInfo.Revision,
+			"version":   about.Doc.BuildInfo.Version,
+			"tier":      tier,
+			"lifecycle": about.Doc.Lifecycle,
+		}
+		p.info.With(labels).Set(1)
+		p.series[key] = append(p.series[key], labels)
+	}
+	return true, nil
+}
+
+func (p *prometheusExporter) remove(service service) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.series[service.key()]; !ok {
+		return false, nil
+	}
+	p.deleteSeries(service.key())
+	return true, nil
+}
+
+// deleteSeries removes the series of a service. Callers must hold the mutex.
+func (p *prometheusExporter) deleteSeries(key string) {
+	for _, labels := range p.series[key] {
+		p.info.Delete(labels)
+	}
+	delete(p.series, key)
+}
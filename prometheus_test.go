@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusExporter(t *testing.T) {
+	assert := assert.New(t)
+	registry := prometheus.NewRegistry()
+	p, err := newPrometheusExporter(registry)
+	assert.NoError(err)
+
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{
+		Owners:    []owner{{Name: "Billing", Slack: "#billing"}, {Name: "Platform", Slack: "#platform"}},
+		BuildInfo: buildInfo{Revision: "abc123", Version: "1.2.0"},
+		Tier:      1,
+		Lifecycle: lifecycleProduction,
+	}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+	assert.Equal("published", outcome(p.handle(refdata)))
+	assert.Equal("published", outcome(p.handle(crm)))
+
+	assert.NoError(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP service_about_info Metadata of the services from their /__/about, always 1.
+# TYPE service_about_info gauge
+service_about_info{lifecycle="",name="uw-service-crm",namespace="crm",owner="",revision="",slack="",tier="",version=""} 1
+service_about_info{lifecycle="production",name="uw-service-refdata",namespace="billing",owner="Billing",revision="abc123",slack="#billing",tier="1",version="1.2.0"} 1
+service_about_info{lifecycle="production",name="uw-service-refdata",namespace="billing",owner="Platform",revision="abc123",slack="#platform",tier="1",version="1.2.0"} 1
+`), "service_about_info"))
+
+	updated := refdata
+	updated.Doc.Owners = []owner{{Name: "Billing", Slack: "#billing"}}
+	updated.Doc.BuildInfo.Revision = "def456"
+	assert.Equal("published", outcome(p.handle(updated)))
+	assert.Equal("published", outcome(p.remove(crm.Service)))
+
+	assert.NoError(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP service_about_info Metadata of the services from their /__/about, always 1.
+# TYPE service_about_info gauge
+service_about_info{lifecycle="production",name="uw-service-refdata",namespace="billing",owner="Billing",revision="def456",slack="#billing",tier="1",version="1.2.0"} 1
+`), "service_about_info"))
+}
+
+func TestPrometheusExporterRegistersOnce(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	_, err := newPrometheusExporter(registry)
+	assert.NoError(t, err)
+	_, err = newPrometheusExporter(registry)
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const currentSchemaVersion = "1"
+
+const problemSchemaViolation = "schema-violation"
+
+//go:embed schema/*.json
+var embeddedSchemas embed.FS
+
+// aboutSchemas are the published versions of the /__/about json schema,
+// keyed by schema-version.
+var aboutSchemas = func() map[string]*gojsonschema.Schema {
+	files, err := embeddedSchemas.ReadDir("schema")
+	if err != nil {
+		panic(err)
+	}
+	schemas := make(map[string]*gojsonschema.Schema)
+	for _, f := range files {
+		version := strings.TrimSuffix(strings.TrimPrefix(f.Name(), "about-v"), ".json")
+		b, err := embeddedSchemas.ReadFile(schemaFile(version))
+		if err != nil {
+			panic(err)
+		}
+		s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(b))
+		if err != nil {
+			panic(fmt.Errorf("Invalid schema %v: (%v)", f.Name(), err))
+		}
+		schemas[version] = s
+	}
+	return schemas
+}()
+
+func schemaFile(version string) string {
+	return "schema/about-v" + version + ".json"
+}
+
+// validateSchema checks a raw about document against the schema version it
+// declares, or the current one if it declares none or an unknown one.
+func validateSchema(raw []byte, version string) ([]problem, error) {
+	schema, ok := aboutSchemas[version]
+	if !ok {
+		schema = aboutSchemas[currentSchemaVersion]
+	}
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		return nil, err
+	}
+	problems := []problem{}
+	for _, e := range result.Errors() {
+		problems = append(problems, problem{Kind: problemSchemaViolation, Message: e.String()})
+	}
+	return problems, nil
+}
+
+// schemaHandler serves the current schema, or the version in the path.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	version, ok := mux.Vars(r)["version"]
+	if !ok {
+		version = currentSchemaVersion
+	}
+	if _, ok := aboutSchemas[version]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Schema version not found"))
+		return
+	}
+	b, err := embeddedSchemas.ReadFile(schemaFile(version))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Couldn't read schema"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	serveContent(w, r, b, time.Time{})
+}
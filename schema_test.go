@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		version  string
+		problems []problem
+	}{
+		{"Valid doc", `{"schema-version":"1","name":"uw-service-refdata","tier":1,"lifecycle":"production","runbook":"https://wiki/runbook","repository":"https://github.com/utilitywarehouse/uw-service-refdata","build-info":{"ci-job":"https://circleci/42"},"owners":[{"name":"Billing","slack":"#billing"}],"slo":"99.9%"}`, "1", []problem{}},
+		{"Valid doc without version", `{"name":"uw-service-refdata"}`, "", []problem{}},
+		{"Missing name", `{"description":"refdata"}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "(root): name is required"},
+		}},
+		{"Wrong types", `{"name":"uw-service-refdata","tier":"1","owners":{"name":"Billing"}}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "owners: Invalid type. Expected: array, given: object"},
+			{Kind: problemSchemaViolation, Message: "tier: Invalid type. Expected: integer, given: string"},
+		}},
+		{"Tier out of range", `{"name":"uw-service-refdata","tier":4}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "tier: Must be less than or equal to 3"},
+		}},
+		{"Tier below range", `{"name":"uw-service-refdata","tier":0}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "tier: Must be greater than or equal to 1"},
+		}},
+		{"Unknown lifecycle", `{"name":"uw-service-refdata","lifecycle":"beta"}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "lifecycle: lifecycle must be one of the following: \"experimental\", \"production\", \"deprecated\""},
+		}},
+		{"Runbook not an http url", `{"name":"uw-service-refdata","runbook":"ftp://wiki/runbook"}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "runbook: Does not match pattern '^https?://'"},
+		}},
+		{"Repository not an http url", `{"name":"uw-service-refdata","repository":"git@github.com:utilitywarehouse/uw-service-refdata.git"}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "repository: Does not match pattern '^https?://'"},
+			{Kind: problemSchemaViolation, Message: "repository: Does not match format 'uri'"},
+		}},
+		{"CI job not a url", `{"name":"uw-service-refdata","build-info":{"ci-job":"circleci/42"}}`, "", []problem{
+			{Kind: problemSchemaViolation, Message: "build-info.ci-job: Does not match pattern '^https?://'"},
+			{Kind: problemSchemaViolation, Message: "build-info.ci-job: Does not match format 'uri'"},
+		}},
+		{"Unknown version", `{"schema-version":"99","name":"uw-service-refdata"}`, "99", []problem{
+			{Kind: problemSchemaViolation, Message: "schema-version: schema-version must be one of the following: \"1\""},
+		}},
+	}
+	for _, test := range tests {
+		problems, err := validateSchema([]byte(test.raw), test.version)
+		assert.NoError(t, err, test.name)
+		assert.ElementsMatch(t, test.problems, problems, test.name)
+	}
+}
+
+func TestSchemaHandler(t *testing.T) {
+	assert := assert.New(t)
+	m := router(newHTTPExporter(testTemplates, testScorer, testPolicies, newExporterHealth(3)))
+	m.HandleFunc("/__/schema/about", schemaHandler)
+	m.HandleFunc("/__/schema/about/{version}", schemaHandler)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, newRequest("GET", "/__/schema/about", "", nil))
+	assert.Equal(200, rec.Code)
+	assert.Equal("application/schema+json", rec.Header().Get("Content-Type"))
+	assert.Contains(rec.Body.String(), "\"$id\": \"https://github.com/utilitywarehouse/uw-service-about-aggregator/schema/about-v1.json\"")
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, newRequest("GET", "/__/schema/about/1", "", nil))
+	assert.Equal(200, rec.Code)
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, newRequest("GET", "/__/schema/about/2", "", nil))
+	assert.Equal(404, rec.Code)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scoringRule awards Weight points to the services satisfying the check
+// with the same name.
+type scoringRule struct {
+	Rule   string  `json:"rule"`
+	Weight float64 `json:"weight"`
+	// MaxAge is how old a build can be for recent-build, e.g. "2160h".
+	MaxAge string `json:"max-age,omitempty"`
+	maxAge time.Duration
+}
+
+var scoringChecks = map[string]func(a about, r scoringRule, now time.Time) bool{
+	"description": func(a about, r scoringRule, now time.Time) bool { return a.Doc.Description != "" },
+	"owners":      func(a about, r scoringRule, now time.Time) bool { return len(a.Doc.Owners) > 0 },
+	"owners-slack": func(a about, r scoringRule, now time.Time) bool {
+		for _, o := range a.Doc.Owners {
+			if o.Slack == "" {
+				return false
+			}
+		}
+		return len(a.Doc.Owners) > 0
+	},
+	"links":      func(a about, r scoringRule, now time.Time) bool { return len(a.Doc.Links) > 0 },
+	"runbook":    func(a about, r scoringRule, now time.Time) bool { return a.Doc.Runbook != "" },
+	"on-call":    func(a about, r scoringRule, now time.Time) bool { return a.Doc.OnCall != "" },
+	"repository": func(a about, r scoringRule, now time.Time) bool { return a.Doc.Repository != "" },
+	"tier":       func(a about, r scoringRule, now time.Time) bool { return a.Doc.Tier != 0 },
+	"lifecycle":  func(a about, r scoringRule, now time.Time) bool { return a.Doc.Lifecycle != "" },
+	"recent-build": func(a about, r scoringRule, now time.Time) bool {
+		age, ok := a.Doc.BuildInfo.age(now)
+		return ok && age <= r.maxAge
+	},
+	"no-problems": func(a about, r scoringRule, now time.Time) bool { return len(lint(a)) == 0 },
+}
+
+var defaultScoringRules = []scoringRule{
+	{Rule: "description", Weight: 2},
+	{Rule: "owners", Weight: 2},
+	{Rule: "owners-slack", Weight: 1},
+	{Rule: "links", Weight: 1},
+	{Rule: "runbook", Weight: 2},
+	{Rule: "on-call", Weight: 1},
+	{Rule: "repository", Weight: 1},
+	{Rule: "tier", Weight: 1},
+	{Rule: "lifecycle", Weight: 1},
+	{Rule: "recent-build", Weight: 1, MaxAge: "2160h"},
+	{Rule: "no-problems", Weight: 1},
+}
+
+type scorer struct {
+	rules []scoringRule
+}
+
+func newScorer(rules []scoringRule) (*scorer, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("At least one scoring rule is required")
+	}
+	parsed := []scoringRule{}
+	for _, r := range rules {
+		if _, ok := scoringChecks[r.Rule]; !ok {
+			return nil, fmt.Errorf("Unknown scoring rule %q", r.Rule)
+		}
+		if r.Weight <= 0 {
+			return nil, fmt.Errorf("Scoring rule %v must have a positive weight", r.Rule)
+		}
+		if r.Rule == "recent-build" {
+			maxAge, err := time.ParseDuration(r.MaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("Scoring rule %v needs a valid max-age: (%v)", r.Rule, err)
+			}
+			r.maxAge = maxAge
+		}
+		parsed = append(parsed, r)
+	}
+	return &scorer{rules: parsed}, nil
+}
+
+// loadScorer reads scoring rules from a json file, or uses the default
+// rules if path is empty.
+func loadScorer(path string) (*scorer, error) {
+	if path == "" {
+		return newScorer(defaultScoringRules)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read scoring rules: (%v)", err)
+	}
+	var rules []scoringRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("Could not json decode scoring rules in %v: (%v)", path, err)
+	}
+	return newScorer(rules)
+}
+
+type serviceScore struct {
+	Service service `json:"service"`
+	// Score is the percentage of the weight of the rules satisfied.
+	Score float64 `json:"score"`
+	// Missing are the rules not satisfied.
+	Missing []string `json:"missing"`
+}
+
+// groupScore is the average score of the services of a namespace or owner.
+type groupScore struct {
+	Name     string  `json:"name"`
+	Score    float64 `json:"score"`
+	Services int     `json:"services"`
+}
+
+type scoreboard struct {
+	Services   []serviceScore `json:"services"`
+	Namespaces []groupScore   `json:"namespaces"`
+	Owners     []groupScore   `json:"owners"`
+}
+
+func (s *scorer) score(a about, now time.Time) serviceScore {
+	total, satisfied := 0.0, 0.0
+	missing := []string{}
+	for _, r := range s.rules {
+		total += r.Weight
+		if scoringChecks[r.Rule](a, r, now) {
+			satisfied += r.Weight
+		} else {
+			missing = append(missing, r.Rule)
+		}
+	}
+	return serviceScore{Service: a.Service, Score: round(100 * satisfied / total), Missing: missing}
+}
+
+// scoreboard scores abouts and ranks services, namespaces and owners from
+// best to worst documented.
+func (s *scorer) scoreboard(abouts []about, now time.Time) scoreboard {
+	b := scoreboard{Services: []serviceScore{}}
+	scores := make(map[string]float64)
+	namespaces := make(map[string][]float64)
+	for _, a := range abouts {
+		score := s.score(a, now)
+		b.Services = append(b.Services, score)
+		scores[a.Service.key()] = score.Score
+		namespaces[a.Service.Namespace] = append(namespaces[a.Service.Namespace], score.Score)
+	}
+	owners := make(map[string][]float64)
+	index := newOwnerIndex(abouts)
+	for _, t := range index.Owners {
+		for _, svc := range t.Services {
+			owners[t.Name] = append(owners[t.Name], scores[svc.key()])
+		}
+	}
+	for _, svc := range index.Unowned {
+		owners[noOwner] = append(owners[noOwner], scores[svc.key()])
+	}
+	sort.SliceStable(b.Services, func(i, j int) bool { return b.Services[i].Score > b.Services[j].Score })
+	b.Namespaces = rank(namespaces)
+	b.Owners = rank(owners)
+	return b
+}
+
+// byKey returns the scores of the services keyed by service key.
+func (b scoreboard) byKey() map[string]serviceScore {
+	scores := make(map[string]serviceScore)
+	for _, s := range b.Services {
+		scores[s.Service.key()] = s
+	}
+	return scores
+}
+
+func rank(groups map[string][]float64) []groupScore {
+	ranked := []groupScore{}
+	for name, scores := range groups {
+		sum := 0.0
+		for _, s := range scores {
+			sum += s
+		}
+		ranked = append(ranked, groupScore{Name: name, Score: round(sum / float64(len(scores))), Services: len(scores)})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return strings.ToLower(ranked[i].Name) < strings.ToLower(ranked[j].Name)
+	})
+	return ranked
+}
+
+// round rounds to one decimal place.
+func round(f float64) float64 {
+	return math.Round(f*10) / 10
+}
+
+func (h *httpExporter) handleScoresHTTP(w http.ResponseWriter, r *http.Request) {
+	// scores depend on the time, so only the ETag tells whether they changed
+	a, _ := h.list()
+	b := h.scorer.scoreboard(a, time.Now())
+	if r.Header.Get("Accept") == "application/json" {
+		writeJSON(w, r, b, time.Time{})
+	} else {
+		h.writeHTML(w, r, "scores.html", b, time.Time{})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var scoreNow = time.Date(2017, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestScorerScore(t *testing.T) {
+	assert := assert.New(t)
+	s, err := newScorer([]scoringRule{
+		{Rule: "description", Weight: 2},
+		{Rule: "owners-slack", Weight: 1},
+		{Rule: "runbook", Weight: 1},
+		{Rule: "recent-build", Weight: 1, MaxAge: "720h"},
+	})
+	assert.NoError(err)
+
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{
+		Description: "refdata",
+		Owners:      []owner{{Name: "Billing", Slack: "#billing"}},
+		BuildInfo:   buildInfo{BuildTime: "2017-03-01T12:00:00Z"},
+	}}
+	assert.Equal(serviceScore{Service: refdata.Service, Score: 80, Missing: []string{"runbook"}}, s.score(refdata, scoreNow))
+
+	stale := about{Doc: doc{Owners: []owner{{Name: "Billing"}}, BuildInfo: buildInfo{BuildTime: "2016-03-01T12:00:00Z"}}}
+	assert.Equal(serviceScore{Score: 0, Missing: []string{"description", "owners-slack", "runbook", "recent-build"}}, s.score(stale, scoreNow))
+}
+
+func TestScorerScoreboard(t *testing.T) {
+	assert := assert.New(t)
+	s, _ := newScorer([]scoringRule{{Rule: "description", Weight: 1}, {Rule: "runbook", Weight: 2}})
+	a := about{Service: service{Name: "a", Namespace: "billing"}, Doc: doc{Description: "a", Runbook: "https://wiki", Owners: []owner{{Name: "Billing"}}}}
+	b := about{Service: service{Name: "b", Namespace: "billing"}, Doc: doc{Description: "b", Owners: []owner{{Name: "Billing"}, {Name: "Platform"}}}}
+	c := about{Service: service{Name: "c", Namespace: "crm"}}
+
+	assert.Equal(scoreboard{
+		Services: []serviceScore{
+			{Service: a.Service, Score: 100, Missing: []string{}},
+			{Service: b.Service, Score: 33.3, Missing: []string{"runbook"}},
+			{Service: c.Service, Score: 0, Missing: []string{"description", "runbook"}},
+		},
+		Namespaces: []groupScore{{Name: "billing", Score: 66.7, Services: 2}, {Name: "crm", Score: 0, Services: 1}},
+		Owners:     []groupScore{{Name: "Billing", Score: 66.7, Services: 2}, {Name: "Platform", Score: 33.3, Services: 1}, {Name: noOwner, Score: 0, Services: 1}},
+	}, s.scoreboard([]about{a, b, c}, scoreNow))
+}
+
+func TestNewScorerErrors(t *testing.T) {
+	assert := assert.New(t)
+	_, err := newScorer(nil)
+	assert.EqualError(err, "At least one scoring rule is required")
+	_, err = newScorer([]scoringRule{{Rule: "readme", Weight: 1}})
+	assert.EqualError(err, "Unknown scoring rule \"readme\"")
+	_, err = newScorer([]scoringRule{{Rule: "runbook"}})
+	assert.EqualError(err, "Scoring rule runbook must have a positive weight")
+	_, err = newScorer([]scoringRule{{Rule: "recent-build", Weight: 1, MaxAge: "90 days"}})
+	assert.Error(err)
+}
+
+func TestLoadScorer(t *testing.T) {
+	assert := assert.New(t)
+	s, err := loadScorer("")
+	assert.NoError(err)
+	assert.Len(s.rules, len(defaultScoringRules))
+
+	path := filepath.Join(tempDir(t), "rules.json")
+	ioutil.WriteFile(path, []byte(`[{"rule":"runbook","weight":3},{"rule":"recent-build","weight":1,"max-age":"24h"}]`), 0644)
+	s, err = loadScorer(path)
+	assert.NoError(err)
+	assert.Equal([]scoringRule{{Rule: "runbook", Weight: 3}, {Rule: "recent-build", Weight: 1, MaxAge: "24h", maxAge: 24 * time.Hour}}, s.rules)
+
+	_, err = loadScorer(filepath.Join(tempDir(t), "missing.json"))
+	assert.Error(err)
+}
+
+func TestScoresHandler(t *testing.T) {
+	assert := assert.New(t)
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "refdata"}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/scores", "application/json", nil))
+	assert.Equal(200, rec.Code)
+	assert.Contains(rec.Body.String(), "\"namespaces\":[{\"name\":\"billing\",\"score\":14.3,\"services\":1}]")
+
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/scores", "text/html", nil))
+	assert.Contains(rec.Body.String(), "<tr><td>billing</td><td>14.3%</td><td>1</td></tr>")
+
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about", "text/html", nil))
+	assert.Contains(rec.Body.String(), "<span class=\"badge score\" title=\"Documentation score\">14.3%</span>")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// slackExporter posts catalogue changes to a slack incoming webhook. Changes
+// are batched into one message per channel and sent by flush. Changes are
+// routed to the channel configured for the namespace of the service and,
+// with notifyOwners, to the slack channels of its owners; those without a
+// channel go to the default channel of the webhook.
+type slackExporter struct {
+	webhookURL   string
+	channels     map[string]string
+	notifyOwners bool
+	client       httpClient
+	mutex        sync.Mutex //protects abouts, pending, primed and startup
+	abouts       map[string]about
+	pending      map[string][]string
+	// primed is false until the first discovery run completed; services
+	// seen before, or found by that run, aren't announced as new.
+	primed  bool
+	startup map[string]bool
+}
+
+func newSlackExporter(webhookURL string, channels map[string]string, notifyOwners bool, client httpClient) (*slackExporter, error) {
+	if webhookURL == "" {
+		return nil, fmt.Errorf("webhookURL is required")
+	}
+	return &slackExporter{
+		webhookURL:   webhookURL,
+		channels:     channels,
+		notifyOwners: notifyOwners,
+		client:       client,
+		mutex:        sync.Mutex{},
+		abouts:       make(map[string]about),
+		pending:      make(map[string][]string),
+		startup:      make(map[string]bool)}, nil
+}
+
+// parseSlackChannels parses namespace to channel routes such as
+// "billing=#billing,crm=#crm-changes".
+func parseSlackChannels(s string) (map[string]string, error) {
+	channels := make(map[string]string)
+	for _, route := range strings.Split(s, ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Slack channel route %q is not namespace=channel", route)
+		}
+		channels[parts[0]] = parts[1]
+	}
+	return channels, nil
+}
+
+// prime records the services found by the first discovery run, which are
+// announced as new services from then on.
+func (s *slackExporter) prime(services []service) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, svc := range services {
+		if _, ok := s.abouts[svc.key()]; !ok {
+			s.startup[svc.key()] = true
+		}
+	}
+	s.primed = true
+	return nil
+}
+
+func (s *slackExporter) handle(about about) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := about.Service.key()
+	existing, ok := s.abouts[key]
+	s.abouts[key] = about
+	name := about.Service.Namespace + "." + about.Service.Name
+	if !ok && (!s.primed || s.startup[key]) {
+		delete(s.startup, key)
+		return false, nil
+	}
+	if !ok {
+		s.notify(about, fmt.Sprintf("New service %v", name))
+		if about.Broken != "" {
+			s.notify(about, fmt.Sprintf("%v has a broken /__/about: %v", name, about.Broken))
+		}
+		return false, nil
+	}
+	if about.Broken != "" && existing.Broken == "" {
+		s.notify(about, fmt.Sprintf("%v has a broken /__/about: %v", name, about.Broken))
+	}
+	if about.Broken == "" && existing.Broken != "" {
+		s.notify(about, fmt.Sprintf("%v has a working /__/about again", name))
+	}
+	if about.Broken != "" || existing.Broken != "" {
+		return false, nil
+	}
+	if !reflect.DeepEqual(ownerNames(existing.Doc.Owners), ownerNames(about.Doc.Owners)) {
+		s.notify(about, fmt.Sprintf("%v owners changed from %v to %v", name, ownerList(existing.Doc.Owners), ownerList(about.Doc.Owners)))
+	}
+	if existing.Doc.BuildInfo.Revision != about.Doc.BuildInfo.Revision {
+		s.notify(about, fmt.Sprintf("%v revision changed from %v to %v", name, existing.Doc.BuildInfo.Revision, about.Doc.BuildInfo.Revision))
+	}
+	return false, nil
+}
+
+func (s *slackExporter) remove(service service) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.startup, service.key())
+	existing, ok := s.abouts[service.key()]
+	if !ok {
+		return false, nil
+	}
+	delete(s.abouts, service.key())
+	s.notify(existing, fmt.Sprintf("%v.%v was removed", service.Namespace, service.Name))
+	return false, nil
+}
+
+// notify queues message for the channels of a. Callers must hold the mutex.
+func (s *slackExporter) notify(a about, message string) {
+	for _, channel := range s.route(a) {
+		s.pending[channel] = append(s.pending[channel], message)
+	}
+}
+
+// route returns the channels to notify about a, "" being the default
+// channel of the webhook.
+func (s *slackExporter) route(a about) []string {
+	channels := []string{}
+	if c, ok := s.channels[a.Service.Namespace]; ok {
+		channels = append(channels, c)
+	}
+	if s.notifyOwners {
+		for _, o := range a.Doc.Owners {
+			if o.Slack != "" && !contains(channels, o.Slack) {
+				channels = append(channels, o.Slack)
+			}
+		}
+	}
+	if len(channels) == 0 {
+		channels = append(channels, "")
+	}
+	return channels
+}
+
+// flush posts the queued changes, one message per channel. Messages that
+// couldn't be posted stay queued, ahead of the newer ones.
+func (s *slackExporter) flush() (bool, error) {
+	s.mutex.Lock()
+	pending := s.pending
+	s.pending = make(map[string][]string)
+	s.mutex.Unlock()
+	channels := []string{}
+	for c := range pending {
+		channels = append(channels, c)
+	}
+	sort.Strings(channels)
+	failed := []string{}
+	for _, c := range channels {
+		if err := s.post(c, strings.Join(pending[c], "\n")); err != nil {
+			failed = append(failed, err.Error())
+			s.mutex.Lock()
+			s.pending[c] = append(pending[c], s.pending[c]...)
+			s.mutex.Unlock()
+		}
+	}
+	if len(failed) > 0 {
+		return false, fmt.Errorf("Could not post to slack: (%v)", strings.Join(failed, ", "))
+	}
+	return len(channels) > 0, nil
+}
+
+// queueLength returns how many changes wait to be posted.
+func (s *slackExporter) queueLength() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, messages := range s.pending {
+		n += len(messages)
+	}
+	return n
+}
+
+type slackMessage struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+}
+
+func (s *slackExporter) post(channel string, text string) error {
+	payload := new(bytes.Buffer)
+	json.NewEncoder(payload).Encode(slackMessage{Channel: channel, Text: text})
+	req, err := http.NewRequest("POST", s.webhookURL, payload)
+	if err != nil {
+		return fmt.Errorf("Could not create slack request: (%v)", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Could not get response from slack: (%v)", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func ownerNames(owners []owner) []string {
+	names := []string{}
+	for _, o := range owners {
+		names = append(names, o.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ownerList(owners []owner) string {
+	if len(owners) == 0 {
+		return "nobody"
+	}
+	return strings.Join(ownerNames(owners), ", ")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slackStandIn records the messages posted to a fake incoming webhook.
+type slackStandIn struct {
+	mutex    sync.Mutex
+	messages []slackMessage
+	status   int
+}
+
+func (s *slackStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var m slackMessage
+	json.NewDecoder(r.Body).Decode(&m)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messages = append(s.messages, m)
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+	}
+}
+
+func newPrimedSlackExporter(t *testing.T, channels map[string]string, notifyOwners bool) (*slackExporter, *slackStandIn) {
+	standIn := &slackStandIn{}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	s, err := newSlackExporter(server.URL, channels, notifyOwners, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.prime(nil)
+	return s, standIn
+}
+
+func TestSlackExporterBatchesChanges(t *testing.T) {
+	assert := assert.New(t)
+	s, standIn := newPrimedSlackExporter(t, map[string]string{"billing": "#billing-changes"}, false)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing"}}, BuildInfo: buildInfo{Revision: "abc"}}}
+	updated := about{Service: refdata.Service, Doc: doc{Owners: []owner{{Name: "Platform"}}, BuildInfo: buildInfo{Revision: "def"}}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+
+	s.handle(refdata)
+	s.handle(updated)
+	s.handle(crm)
+	s.handle(crm)
+	s.remove(crm.Service)
+	s.remove(service{Name: "unknown", Namespace: "crm"})
+	assert.Equal("published", outcome(s.flush()))
+
+	assert.Equal([]slackMessage{
+		{Text: "New service crm.uw-service-crm\ncrm.uw-service-crm was removed"},
+		{Channel: "#billing-changes", Text: "New service billing.uw-service-refdata\nbilling.uw-service-refdata owners changed from Billing to Platform\nbilling.uw-service-refdata revision changed from abc to def"},
+	}, standIn.messages)
+
+	assert.Equal("nothing published", outcome(s.flush()))
+	assert.Len(standIn.messages, 2, "nothing is posted without changes")
+}
+
+func TestSlackExporterNotifiesOwners(t *testing.T) {
+	assert := assert.New(t)
+	s, standIn := newPrimedSlackExporter(t, map[string]string{"billing": "#billing"}, true)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing", Slack: "#billing"}, {Name: "Platform", Slack: "#platform"}, {Name: "Nobody"}}}}
+
+	s.handle(refdata)
+	s.handle(about{Service: refdata.Service, Doc: refdata.Doc, Broken: "/__/about returned 500"})
+	s.handle(refdata)
+	assert.Equal("published", outcome(s.flush()))
+
+	text := "New service billing.uw-service-refdata\nbilling.uw-service-refdata has a broken /__/about: /__/about returned 500\nbilling.uw-service-refdata has a working /__/about again"
+	assert.Equal([]slackMessage{{Channel: "#billing", Text: text}, {Channel: "#platform", Text: text}}, standIn.messages)
+}
+
+func TestSlackExporterSkipsServicesFoundAtStartup(t *testing.T) {
+	assert := assert.New(t)
+	standIn := &slackStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	s, _ := newSlackExporter(server.URL, nil, false, http.DefaultClient)
+	refdata := service{Name: "uw-service-refdata", Namespace: "billing"}
+	crm := service{Name: "uw-service-crm", Namespace: "crm"}
+	ledger := service{Name: "uw-service-ledger", Namespace: "billing"}
+
+	// refdata is fetched before the first run completes, crm after
+	s.handle(about{Service: refdata})
+	assert.NoError(s.prime([]service{refdata, crm}))
+	s.handle(about{Service: crm})
+	s.handle(about{Service: ledger})
+	s.remove(refdata)
+	assert.Equal("published", outcome(s.flush()))
+
+	assert.Equal([]slackMessage{{Text: "New service billing.uw-service-ledger\nbilling.uw-service-refdata was removed"}}, standIn.messages)
+}
+
+func TestSlackExporterPostErrors(t *testing.T) {
+	assert := assert.New(t)
+	s, standIn := newPrimedSlackExporter(t, nil, false)
+	standIn.status = http.StatusNotFound
+
+	s.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})
+	assert.Equal("Could not post to slack: (Slack webhook returned status 404)", outcome(s.flush()))
+	assert.Equal(1, s.queueLength())
+
+	standIn.status = 0
+	s.handle(about{Service: service{Name: "uw-service-crm", Namespace: "crm"}})
+	assert.Equal("published", outcome(s.flush()))
+	assert.Equal(0, s.queueLength())
+	assert.Equal(slackMessage{Text: "New service billing.uw-service-refdata\nNew service crm.uw-service-crm"}, standIn.messages[1])
+}
+
+func TestParseSlackChannels(t *testing.T) {
+	assert := assert.New(t)
+	channels, err := parseSlackChannels("billing=#billing, crm=#crm-changes")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"billing": "#billing", "crm": "#crm-changes"}, channels)
+
+	channels, err = parseSlackChannels("")
+	assert.NoError(err)
+	assert.Empty(channels)
+
+	_, err = parseSlackChannels("billing")
+	assert.EqualError(err, "Slack channel route \"billing\" is not namespace=channel")
+}
+
+func TestNewSlackExporterRequiresWebhook(t *testing.T) {
+	_, err := newSlackExporter("", nil, false, http.DefaultClient)
+	assert.EqualError(t, err, "webhookURL is required")
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotTimeFormat names snapshots so that they sort by time.
+const snapshotTimeFormat = "20060102T150405.000Z"
+
+// snapshot is the catalogue at a point in time.
+type snapshot struct {
+	Time     time.Time `json:"time"`
+	Services []about   `json:"services"`
+}
+
+// snapshotStore is where snapshots are kept, keys being relative to the
+// bucket.
+type snapshotStore interface {
+	put(key string, content []byte) error
+	list(prefix string) ([]string, error)
+	delete(key string) error
+}
+
+// snapshotExporter uploads the catalogue as a timestamped json file,
+// prefix<time>.json, after it changes and at least every interval, and
+// deletes the snapshots older than retention, if set.
+type snapshotExporter struct {
+	store     snapshotStore
+	prefix    string
+	interval  time.Duration
+	retention time.Duration
+	mutex     sync.Mutex //protects abouts, changed and lastUpload
+	abouts    map[string]about
+	changed   bool
+	// lastUpload is when the last snapshot was taken
+	lastUpload time.Time
+}
+
+func newSnapshotExporter(store snapshotStore, prefix string, interval time.Duration, retention time.Duration) *snapshotExporter {
+	return &snapshotExporter{
+		store:     store,
+		prefix:    prefix,
+		interval:  interval,
+		retention: retention,
+		mutex:     sync.Mutex{},
+		abouts:    make(map[string]about)}
+}
+
+func (s *snapshotExporter) handle(about about) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, ok := s.abouts[about.Service.key()]
+	s.abouts[about.Service.key()] = about
+	if !ok || !existing.sameContent(about) {
+		s.changed = true
+	}
+	return false, nil
+}
+
+func (s *snapshotExporter) remove(service service) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.abouts[service.key()]; !ok {
+		return false, nil
+	}
+	delete(s.abouts, service.key())
+	s.changed = true
+	return false, nil
+}
+
+// upload takes a snapshot if the catalogue changed or the last one is older
+// than interval, then deletes the expired snapshots. It reports whether it
+// took a snapshot.
+func (s *snapshotExporter) upload(now time.Time) (bool, error) {
+	s.mutex.Lock()
+	if !s.changed && now.Sub(s.lastUpload) < s.interval {
+		s.mutex.Unlock()
+		return false, nil
+	}
+	snap := snapshot{Time: now.UTC(), Services: []about{}}
+	for _, a := range s.abouts {
+		snap.Services = append(snap.Services, a)
+	}
+	s.changed = false
+	s.mutex.Unlock()
+	sort.Slice(snap.Services, func(i, j int) bool { return snap.Services[i].Service.key() < snap.Services[j].Service.key() })
+
+	content, err := json.Marshal(snap)
+	if err != nil {
+		return false, fmt.Errorf("Could not json encode snapshot: (%v)", err)
+	}
+	if err := s.store.put(s.prefix+snap.Time.Format(snapshotTimeFormat)+".json", content); err != nil {
+		s.mutex.Lock()
+		s.changed = true
+		s.mutex.Unlock()
+		return false, err
+	}
+	s.mutex.Lock()
+	s.lastUpload = now
+	s.mutex.Unlock()
+	err = s.expire(now)
+	return err == nil, err
+}
+
+// expire deletes the snapshots older than retention.
+func (s *snapshotExporter) expire(now time.Time) error {
+	if s.retention <= 0 {
+		return nil
+	}
+	keys, err := s.store.list(s.prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		name := strings.TrimSuffix(strings.TrimPrefix(key, s.prefix), ".json")
+		taken, err := time.Parse(snapshotTimeFormat, name)
+		if err != nil {
+			// not a snapshot
+			continue
+		}
+		if now.Sub(taken) > s.retention {
+			if err := s.store.delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// queueLength returns 1 while a change isn't in a snapshot yet.
+func (s *snapshotExporter) queueLength() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.changed {
+		return 1
+	}
+	return 0
+}
+
+// s3Store keeps snapshots in a bucket of S3 or of an S3 compatible store
+// such as MinIO, using path style urls, endpoint/bucket/key, and signature
+// version 4.
+type s3Store struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    httpClient
+}
+
+func newS3Store(endpoint string, region string, bucket string, accessKey string, secretKey string, client httpClient) (*s3Store, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	if _, err := url.Parse(endpoint); err != nil || endpoint == "" {
+		return nil, fmt.Errorf("S3 endpoint %q is not a valid url", endpoint)
+	}
+	return &s3Store{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    client}, nil
+}
+
+func (s *s3Store) put(key string, content []byte) error {
+	resp, err := s.do("PUT", key, nil, "application/json", content)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	return checkS3Response(resp, "upload "+key)
+}
+
+func (s *s3Store) delete(key string) error {
+	resp, err := s.do("DELETE", key, nil, "", nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	return checkS3Response(resp, "delete "+key)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Store) list(prefix string) ([]string, error) {
+	keys := []string{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do("GET", "", query, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkS3Response(resp, "list "+prefix); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode S3 list of %v: (%v)", prefix, err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *s3Store) do(method string, key string, query url.Values, contentType string, body []byte) (*http.Response, error) {
+	u := s.endpoint + "/" + s3Escape(s.bucket, false)
+	if key != "" {
+		u += "/" + s3Escape(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + s3Query(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create S3 request: (%v)", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(body)
+	signS3(req, hex.EncodeToString(sum[:]), s.accessKey, s.secretKey, s.region, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get response from S3: (%v)", err)
+	}
+	return resp, nil
+}
+
+func checkS3Response(resp *http.Response, action string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("Could not %v in S3: status %d (%v)", action, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// signS3 adds the headers of an AWS signature version 4 to req, signing its
+// host and every header already set.
+func signS3(req *http.Request, payloadHash string, accessKey string, secretKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		s3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v,SignedHeaders=%v,Signature=%v", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Query encodes query sorted by name, as signature version 4 requires.
+func s3Query(query url.Values) string {
+	names := []string{}
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := []string{}
+	for _, name := range names {
+		for _, v := range query[name] {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes all but the unreserved characters and the
+// slashes, which are encoded too with encodeSlash.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// staticExporter writes the catalogue to dir as a static site that can be
+// served without the aggregator: index.html, catalogue.json and the pages of
+// the services, owners and scores at the paths of their /__/ urls, e.g.
+// __/about/billing/uw-service-refdata/index.html, so that links between
+// pages keep working.
+type staticExporter struct {
+	dir       string
+	templates *template.Template
+	scorer    *scorer
+	mutex     sync.Mutex //protects abouts
+	abouts    map[string]about
+}
+
+func newStaticExporter(dir string, templates *template.Template, scorer *scorer) (*staticExporter, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Could not create static site dir %v: (%v)", dir, err)
+	}
+	return &staticExporter{dir: dir, templates: templates, scorer: scorer, mutex: sync.Mutex{}, abouts: make(map[string]about)}, nil
+}
+
+func (s *staticExporter) handle(about about) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := about.Service.key()
+	existing, ok := s.abouts[key]
+	if ok && existing.sameContent(about) {
+		return false, nil
+	}
+	s.abouts[key] = about
+	written, err := s.write()
+	if err != nil {
+		// restore the state so that the next fetch of the service retries
+		if ok {
+			s.abouts[key] = existing
+		} else {
+			delete(s.abouts, key)
+		}
+	}
+	return written, err
+}
+
+func (s *staticExporter) remove(service service) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.abouts[service.key()]; !ok {
+		return false, nil
+	}
+	delete(s.abouts, service.key())
+	return s.write()
+}
+
+// write renders every page, replaces the files that changed and deletes the
+// pages of services and owners that are gone, reporting whether any file was
+// replaced or deleted. Callers must hold the mutex.
+func (s *staticExporter) write() (bool, error) {
+	a := []about{}
+	for _, about := range s.abouts {
+		a = append(a, about)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Service.key() < a[j].Service.key() })
+	now := time.Now()
+	scores := s.scorer.scoreboard(a, now)
+	problems := findProblems(a)
+	owners := newOwnerIndex(a)
+
+	catalogue, err := json.Marshal(a)
+	if err != nil {
+		return false, fmt.Errorf("Could not json encode catalogue: (%v)", err)
+	}
+	files := map[string][]byte{"catalogue.json": catalogue}
+	render := func(path string, name string, data interface{}) error {
+		var b bytes.Buffer
+		if err := s.templates.ExecuteTemplate(&b, name, data); err != nil {
+			return fmt.Errorf("Couldn't render template file for %v: (%v)", path, err)
+		}
+		files[path] = b.Bytes()
+		return nil
+	}
+	view := newCatalogueView(a, groupByNamespace, scores)
+	if err := render("index.html", "main.html", view); err != nil {
+		return false, err
+	}
+	if err := render("__/about/index.html", "main.html", view); err != nil {
+		return false, err
+	}
+	if err := render("__/owners/index.html", "owners.html", owners); err != nil {
+		return false, err
+	}
+	if err := render("__/scores/index.html", "scores.html", scores); err != nil {
+		return false, err
+	}
+	for _, about := range a {
+		path := filepath.Join("__/about", about.Service.Namespace, about.Service.Name, "index.html")
+		if err := render(path, "service.html", newServicePage(about, problems, s.scorer, now)); err != nil {
+			return false, err
+		}
+	}
+	for _, t := range owners.Owners {
+		if !staticOwnerPath(t.Name) {
+			continue
+		}
+		if err := render(filepath.Join("__/owners", t.Name, "index.html"), "owners.html", ownerIndex{Owners: []team{t}}); err != nil {
+			return false, err
+		}
+	}
+
+	written := false
+	for path, content := range files {
+		path = filepath.Join(s.dir, path)
+		if sameFile(path, content) {
+			continue
+		}
+		if err := writeFileAtomic(path, content); err != nil {
+			return written, err
+		}
+		written = true
+	}
+	pruned, err := s.prune(files)
+	return written || pruned, err
+}
+
+// staticOwnerPath tells whether the page of the owner called name can be
+// written at the path of its url, a static file server decoding the escaped
+// slashes of names such as "Billing/Payments" into directories.
+func staticOwnerPath(name string) bool {
+	if strings.Contains(name, `\`) {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// prune deletes the files under __/ that weren't just written, and the
+// directories left empty, reporting whether any file was deleted.
+func (s *staticExporter) prune(files map[string][]byte) (bool, error) {
+	root := filepath.Join(s.dir, "__")
+	dirs := []string{}
+	pruned := false
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := files[rel]; !ok {
+			pruned = true
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return pruned, fmt.Errorf("Could not delete old pages in %v: (%v)", s.dir, err)
+	}
+	// deepest first, so parents are empty by the time they are reached
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, d := range dirs {
+		if entries, err := ioutil.ReadDir(d); err == nil && len(entries) == 0 {
+			os.Remove(d)
+		}
+	}
+	return pruned, nil
+}
+
+// sameFile tells whether path holds content already.
+func sameFile(path string, content []byte) bool {
+	existing, err := ioutil.ReadFile(path)
+	return err == nil && bytes.Equal(existing, content)
+}
+
+// writeFileAtomic replaces path with content through a rename, so readers
+// see either the old or the new file. Files with the same content are left
+// alone to keep their modification time.
+func writeFileAtomic(path string, content []byte) error {
+	if sameFile(path, content) {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Could not create dir %v: (%v)", dir, err)
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("Could not create temporary file in %v: (%v)", dir, err)
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Could not write %v: (%v)", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticExporterWritesSite(t *testing.T) {
+	assert := assert.New(t)
+	dir := filepath.Join(tempDir(t), "site")
+	s, err := newStaticExporter(dir, testTemplates, testScorer)
+	assert.NoError(err)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "Reference data", Owners: []owner{{Name: "Billing", Slack: "#billing"}}}}
+
+	assert.Equal("published", outcome(s.handle(refdata)))
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+	assert.NoError(err)
+	assert.Contains(string(index), "<a href=\"/__/about/billing/uw-service-refdata\">billing.uw-service-refdata</a>")
+	page, err := ioutil.ReadFile(filepath.Join(dir, "__/about/billing/uw-service-refdata/index.html"))
+	assert.NoError(err)
+	assert.Contains(string(page), "Reference data")
+	owner, err := ioutil.ReadFile(filepath.Join(dir, "__/owners/Billing/index.html"))
+	assert.NoError(err)
+	assert.Contains(string(owner), "uw-service-refdata")
+	for _, path := range []string{"__/about/index.html", "__/owners/index.html", "__/scores/index.html"} {
+		_, err := os.Stat(filepath.Join(dir, path))
+		assert.NoError(err, path)
+	}
+	catalogue, err := ioutil.ReadFile(filepath.Join(dir, "catalogue.json"))
+	assert.NoError(err)
+	var abouts []about
+	assert.NoError(json.Unmarshal(catalogue, &abouts))
+	assert.Equal([]about{refdata}, abouts)
+}
+
+func TestStaticExporterSkipsUnchangedServices(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	s, _ := newStaticExporter(dir, testTemplates, testScorer)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "Reference data"}, Fetched: time.Now()}
+
+	assert.Equal("published", outcome(s.handle(refdata)))
+	refetched := refdata
+	refetched.Fetched = refdata.Fetched.Add(time.Minute)
+	assert.Equal("nothing published", outcome(s.handle(refetched)))
+
+	// after a restart the site is there already
+	s, _ = newStaticExporter(dir, testTemplates, testScorer)
+	assert.Equal("nothing published", outcome(s.handle(refdata)))
+}
+
+func TestStaticExporterDeletesRemovedPages(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	s, _ := newStaticExporter(dir, testTemplates, testScorer)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing"}}}}
+	crm := about{Service: service{Name: "uw-service-crm", Namespace: "crm"}}
+	ioutil.WriteFile(filepath.Join(dir, "robots.txt"), []byte("User-agent: *"), 0644)
+
+	assert.Equal("published", outcome(s.handle(refdata)))
+	assert.Equal("published", outcome(s.handle(crm)))
+	assert.Equal("published", outcome(s.remove(refdata.Service)))
+
+	_, err := os.Stat(filepath.Join(dir, "__/about/billing"))
+	assert.True(os.IsNotExist(err), "pages of removed services are deleted")
+	_, err = os.Stat(filepath.Join(dir, "__/owners/Billing"))
+	assert.True(os.IsNotExist(err), "pages of owners without services are deleted")
+	_, err = os.Stat(filepath.Join(dir, "__/about/crm/uw-service-crm/index.html"))
+	assert.NoError(err)
+	_, err = os.Stat(filepath.Join(dir, "robots.txt"))
+	assert.NoError(err, "files outside __/ are left alone")
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "a", "b.html")
+
+	assert.NoError(writeFileAtomic(path, []byte("first")))
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+	assert.NoError(writeFileAtomic(path, []byte("first")))
+	info, _ := os.Stat(path)
+	assert.Equal(old.Unix(), info.ModTime().Unix(), "unchanged files are not rewritten")
+
+	assert.NoError(writeFileAtomic(path, []byte("second")))
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal("second", string(b))
+	entries, _ := ioutil.ReadDir(filepath.Dir(path))
+	assert.Len(entries, 1, "no temporary files are left")
+}
+
+func TestNewStaticExporterRequiresDir(t *testing.T) {
+	_, err := newStaticExporter("", testTemplates, testScorer)
+	assert.EqualError(t, err, "dir is required")
+}
+
+func TestStaticOwnerPath(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(staticOwnerPath("Billing"))
+	assert.True(staticOwnerPath("Billing/Payments"))
+	assert.False(staticOwnerPath("../etc"))
+	assert.False(staticOwnerPath("Billing//Payments"))
+	assert.False(staticOwnerPath(`Billing\Payments`))
+	assert.False(staticOwnerPath("."))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// problem is something wrong with a service that its owners should fix.
+type problem struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+type serviceStatus struct {
+	Service  service   `json:"service"`
+	Problems []problem `json:"problems"`
+}
+
+type catalogueStatus struct {
+	Services  []serviceStatus `json:"services"`
+	Exporters []exporterState `json:"exporters,omitempty"`
+}
+
+// findProblems returns the problems of abouts, keyed by service key.
+func findProblems(abouts []about) map[string][]problem {
+	problems := newGraph(abouts).problems()
+	for _, a := range abouts {
+		if p := lint(a); len(p) > 0 {
+			problems[a.Service.key()] = append(p, problems[a.Service.key()]...)
+		}
+	}
+	return problems
+}
+
+func newCatalogueStatus(abouts []about) catalogueStatus {
+	problems := findProblems(abouts)
+	s := catalogueStatus{Services: []serviceStatus{}}
+	for _, a := range abouts {
+		p := problems[a.Service.key()]
+		if p == nil {
+			p = []problem{}
+		}
+		s.Services = append(s.Services, serviceStatus{Service: a.Service, Problems: p})
+	}
+	return s
+}
+
+func (h *httpExporter) handleStatusHTTP(w http.ResponseWriter, r *http.Request) {
+	a, _ := h.list()
+	s := newCatalogueStatus(a)
+	s.Exporters = h.health.list()
+	// the state of the exporters changes without the catalogue changing
+	writeJSON(w, r, s, time.Time{})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusHandler(t *testing.T) {
+	assert := assert.New(t)
+	owners := []owner{{Name: "Billing", Slack: "#billing"}}
+	e := createHTTPExporterAndHandle(about{Service: service{Name: "uw-service-account", Namespace: "crm"}, Doc: doc{Description: "account", Owners: owners, Dependencies: []dependency{{Name: "uw-service-customer"}}}})
+	e.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Description: "refdata", Owners: owners}})
+
+	rec := httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/status", "application/json", nil))
+	assert.Equal(200, rec.Code)
+	assert.Equal("{\"services\":["+
+		"{\"service\":{\"Name\":\"uw-service-refdata\",\"Namespace\":\"billing\",\"BaseURL\":\"\"},\"problems\":[]},"+
+		"{\"service\":{\"Name\":\"uw-service-account\",\"Namespace\":\"crm\",\"BaseURL\":\"\"},\"problems\":[{\"kind\":\"unknown-dependency\",\"message\":\"Depends on crm/uw-service-customer which is not in the catalogue\"}]}"+
+		"]}\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about", "text/html", nil))
+	assert.Contains(rec.Body.String(), "<span class=\"status warning\">1 problem</span>")
+	assert.Contains(rec.Body.String(), "<li>Depends on crm/uw-service-customer which is not in the catalogue</li>")
+
+	rec = httptest.NewRecorder()
+	router(e).ServeHTTP(rec, newRequest("GET", "/__/about/crm/uw-service-account", "text/html", nil))
+	assert.Contains(rec.Body.String(), "<li>Depends on crm/uw-service-customer which is not in the catalogue</li>")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+// loadTemplates parses the templates compiled into the binary. When dir is
+// set, every *.html file in it replaces the built-in template of the same
+// name, so a typo or a broken override fails at startup rather than on the
+// first request.
+func loadTemplates(dir string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(embeddedTemplates, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse built-in templates: (%v)", err)
+	}
+	if dir == "" {
+		return t, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read template dir: (%v)", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Template dir %v is not a directory", dir)
+	}
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not list templates in %v: (%v)", dir, err)
+	}
+	if len(overrides) == 0 {
+		return nil, fmt.Errorf("No *.html templates found in %v", dir)
+	}
+	for _, f := range overrides {
+		if t.Lookup(filepath.Base(f)) == nil {
+			return nil, fmt.Errorf("Template %v does not override a built-in template, expected one of %v", f, t.DefinedTemplates())
+		}
+	}
+	if t, err = t.ParseFiles(overrides...); err != nil {
+		return nil, fmt.Errorf("Could not parse templates in %v: (%v)", dir, err)
+	}
+	return t, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"slackURL":   slackURL,
+	"key":        func(s service) string { return s.key() },
+	"json":       toJSON,
+	"buildAge":   buildAge,
+	"pathEscape": url.PathEscape,
+}
+
+// toJSON renders v, typically a value of doc.Extra, as json.
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// slackURL links a slack channel such as "#billing" to the channel in
+// whichever workspace the reader is signed in to.
+func slackURL(channel string) string {
+	return "https://slack.com/app_redirect?channel=" + url.QueryEscape(strings.TrimPrefix(channel, "#"))
+}
+
+const (
+	groupByNamespace = "namespace"
+	groupByOwner     = "owner"
+	noOwner          = "No owner"
+)
+
+// catalogueView is the data rendered by main.html. Problems and Scores are
+// keyed by service key.
+type catalogueView struct {
+	GroupBy  string
+	Count    int
+	Groups   []catalogueGroup
+	Problems map[string][]problem
+	Scores   map[string]serviceScore
+}
+
+type catalogueGroup struct {
+	Name   string
+	Abouts []about
+}
+
+// newCatalogueView groups abouts by namespace or by owner. A service with
+// several owners is listed under each of them.
+func newCatalogueView(abouts []about, groupBy string, scores scoreboard) catalogueView {
+	if groupBy != groupByOwner {
+		groupBy = groupByNamespace
+	}
+	groups := make(map[string][]about)
+	for _, a := range abouts {
+		if groupBy == groupByNamespace {
+			groups[a.Service.Namespace] = append(groups[a.Service.Namespace], a)
+			continue
+		}
+		if len(a.Doc.Owners) == 0 {
+			groups[noOwner] = append(groups[noOwner], a)
+		}
+		for _, o := range a.Doc.Owners {
+			groups[o.Name] = append(groups[o.Name], a)
+		}
+	}
+	v := catalogueView{GroupBy: groupBy, Count: len(abouts), Problems: findProblems(abouts), Scores: scores.byKey()}
+	for name, a := range groups {
+		v.Groups = append(v.Groups, catalogueGroup{Name: name, Abouts: a})
+	}
+	sort.Slice(v.Groups, func(i, j int) bool { return v.Groups[i].Name < v.Groups[j].Name })
+	return v
+}
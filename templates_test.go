@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testScorer = func() *scorer {
+	s, err := newScorer(defaultScoringRules)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}()
+
+var testTemplates = func() *template.Template {
+	t, err := loadTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+func TestLoadTemplatesBuiltIn(t *testing.T) {
+	assert := assert.New(t)
+	templates, err := loadTemplates("")
+	assert.NoError(err)
+	assert.NotNil(templates.Lookup("main.html"))
+	assert.NotNil(templates.Lookup("confluence.html"))
+}
+
+func TestLoadTemplatesOverride(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	ioutil.WriteFile(filepath.Join(dir, "main.html"), []byte("custom {{len .Abouts}}"), 0644)
+
+	templates, err := loadTemplates(dir)
+	assert.NoError(err)
+	var b bytes.Buffer
+	assert.NoError(templates.ExecuteTemplate(&b, "main.html", struct{ Abouts []about }{}))
+	assert.Equal("custom 0", b.String())
+	assert.NotNil(templates.Lookup("confluence.html"))
+}
+
+func TestLoadTemplatesInvalidOverrides(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := loadTemplates(filepath.Join(tempDir(t), "missing"))
+	assert.Error(err)
+
+	_, err = loadTemplates(tempDir(t))
+	assert.Error(err)
+
+	dir := tempDir(t)
+	ioutil.WriteFile(filepath.Join(dir, "mian.html"), []byte("typo"), 0644)
+	_, err = loadTemplates(dir)
+	assert.Error(err)
+
+	dir = tempDir(t)
+	ioutil.WriteFile(filepath.Join(dir, "main.html"), []byte("{{.Abouts"), 0644)
+	_, err = loadTemplates(dir)
+	assert.Error(err)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestNewCatalogueView(t *testing.T) {
+	assert := assert.New(t)
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Owners: []owner{{Name: "Billing"}, {Name: "Platform"}}}}
+	account := about{Service: service{Name: "uw-service-account", Namespace: "crm"}, Doc: doc{Owners: []owner{{Name: "Billing"}}}}
+	orphan := about{Service: service{Name: "uw-service-orphan", Namespace: "crm"}}
+	abouts := []about{refdata, account, orphan}
+
+	v := newCatalogueView(abouts, "", scoreboard{})
+	assert.Equal(groupByNamespace, v.GroupBy)
+	assert.Equal(3, v.Count)
+	assert.Equal([]catalogueGroup{
+		{Name: "billing", Abouts: []about{refdata}},
+		{Name: "crm", Abouts: []about{account, orphan}},
+	}, v.Groups)
+	v = newCatalogueView(abouts, groupByOwner, scoreboard{})
+	assert.Equal(groupByOwner, v.GroupBy)
+	assert.Equal([]catalogueGroup{
+		{Name: "Billing", Abouts: []about{refdata, account}},
+		{Name: noOwner, Abouts: []about{orphan}},
+		{Name: "Platform", Abouts: []about{refdata}},
+	}, v.Groups)
+	assert.Equal([]problem{{Kind: problemMissingDescription, Message: "Description is empty"}, {Kind: problemMissingOwners, Message: "No owners"}}, v.Problems["crm/uw-service-orphan"])
+}
+
+func TestSlackURL(t *testing.T) {
+	assert.Equal(t, "https://slack.com/app_redirect?channel=billing", slackURL("#billing"))
+	assert.Equal(t, "https://slack.com/app_redirect?channel=team-billing", slackURL("team-billing"))
+}
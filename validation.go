@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	lifecycleExperimental = "experimental"
+	lifecycleProduction   = "production"
+	lifecycleDeprecated   = "deprecated"
+)
+
+const (
+	minTier = 1
+	maxTier = 3
+)
+
+const problemInvalidField = "invalid-field"
+
+// validate checks the optional structured fields of a doc. Fields that are
+// not set are valid.
+func (d doc) validate() []problem {
+	problems := []problem{}
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, problem{Kind: problemInvalidField, Message: fmt.Sprintf(format, args...)})
+	}
+	if d.Tier != 0 && (d.Tier < minTier || d.Tier > maxTier) {
+		invalid("tier must be between %d and %d, was %d", minTier, maxTier, d.Tier)
+	}
+	switch d.Lifecycle {
+	case "", lifecycleExperimental, lifecycleProduction, lifecycleDeprecated:
+	default:
+		invalid("lifecycle must be one of %v, %v or %v, was %q", lifecycleExperimental, lifecycleProduction, lifecycleDeprecated, d.Lifecycle)
+	}
+	for _, t := range d.Tags {
+		if t == "" || strings.ContainsAny(t, " \t\n") {
+			invalid("tags must be non-empty and without whitespace, was %q", t)
+		}
+	}
+	if d.Runbook != "" && !isURL(d.Runbook) {
+		invalid("runbook must be an http(s) url, was %q", d.Runbook)
+	}
+	if d.Repository != "" && !isURL(d.Repository) {
+		invalid("repository must be an http(s) url, was %q", d.Repository)
+	}
+	if d.OnCall != "" && strings.TrimSpace(d.OnCall) == "" {
+		invalid("on-call must not be blank")
+	}
+	return append(problems, d.BuildInfo.validate()...)
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
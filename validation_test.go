@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      doc
+		problems []string
+	}{
+		{"Empty doc", doc{}, []string{}},
+		{"Valid fields", doc{Tier: 1, Lifecycle: lifecycleProduction, Tags: []string{"billing", "tier-1"}, Runbook: "https://wiki/runbook", OnCall: "PD1234", Repository: "https://github.com/utilitywarehouse/uw-service-refdata"}, []string{}},
+		{"Invalid tier", doc{Tier: 4}, []string{"tier must be between 1 and 3, was 4"}},
+		{"Invalid lifecycle", doc{Lifecycle: "beta"}, []string{"lifecycle must be one of experimental, production or deprecated, was \"beta\""}},
+		{"Invalid tags", doc{Tags: []string{"", "two words"}}, []string{"tags must be non-empty and without whitespace, was \"\"", "tags must be non-empty and without whitespace, was \"two words\""}},
+		{"Invalid urls", doc{Runbook: "wiki/runbook", Repository: "git@github.com:utilitywarehouse/uw-service-refdata.git"}, []string{"runbook must be an http(s) url, was \"wiki/runbook\"", "repository must be an http(s) url, was \"git@github.com:utilitywarehouse/uw-service-refdata.git\""}},
+		{"Blank on-call", doc{OnCall: "  "}, []string{"on-call must not be blank"}},
+	}
+	for _, test := range tests {
+		messages := []string{}
+		for _, p := range test.doc.validate() {
+			assert.Equal(t, problemInvalidField, p.Kind, test.name)
+			messages = append(messages, p.Message)
+		}
+		assert.Equal(t, test.problems, messages, test.name)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookSignatureHeader = "X-About-Signature"
+	webhookEventHeader     = "X-About-Event"
+	webhookDeliveryHeader  = "X-About-Delivery"
+)
+
+// webhookExporter POSTs an add, update or remove event with the full about
+// to each of its urls. Deliveries are signed with an HMAC of the body when
+// there is a secret and retried with exponential backoff; those still
+// failing are written to the dead-letter log.
+type webhookExporter struct {
+	urls       []string
+	secret     string
+	retries    int
+	backoff    time.Duration
+	timeout    time.Duration
+	client     httpClient
+	deadLetter io.Writer
+	mutex      sync.Mutex //protects abouts, lastID and deadLetter
+	abouts     map[string]about
+	lastID     uint64
+}
+
+// deadLetter is a delivery that failed after all retries.
+type deadLetter struct {
+	URL   string    `json:"url"`
+	Event event     `json:"event"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+func newWebhookExporter(urls []string, secret string, retries int, backoff time.Duration, timeout time.Duration, deadLetter io.Writer, client httpClient) (*webhookExporter, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("At least one webhook url is required")
+	}
+	if retries < 0 {
+		return nil, fmt.Errorf("Webhook retries can't be negative")
+	}
+	return &webhookExporter{
+		urls:       urls,
+		secret:     secret,
+		retries:    retries,
+		backoff:    backoff,
+		timeout:    timeout,
+		client:     client,
+		deadLetter: deadLetter,
+		mutex:      sync.Mutex{},
+		abouts:     make(map[string]about)}, nil
+}
+
+func (w *webhookExporter) handle(about about) (bool, error) {
+	w.mutex.Lock()
+	key := about.Service.key()
+	existing, ok := w.abouts[key]
+	if ok && existing.sameContent(about) {
+		w.mutex.Unlock()
+		return false, nil
+	}
+	w.abouts[key] = about
+	eventType := eventAdded
+	if ok {
+		eventType = eventUpdated
+	}
+	ev := w.newEvent(eventType, about)
+	w.mutex.Unlock()
+	err := w.deliver(ev)
+	return err == nil, err
+}
+
+func (w *webhookExporter) remove(service service) (bool, error) {
+	w.mutex.Lock()
+	existing, ok := w.abouts[service.key()]
+	if !ok {
+		w.mutex.Unlock()
+		return false, nil
+	}
+	delete(w.abouts, service.key())
+	ev := w.newEvent(eventRemoved, existing)
+	w.mutex.Unlock()
+	err := w.deliver(ev)
+	return err == nil, err
+}
+
+// newEvent numbers a new event. Callers must hold the mutex.
+func (w *webhookExporter) newEvent(eventType string, about about) event {
+	w.lastID++
+	return event{ID: w.lastID, Type: eventType, About: about}
+}
+
+// deliver sends ev to every url and returns the urls it couldn't be
+// delivered to.
+func (w *webhookExporter) deliver(ev event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("Could not json encode webhook event: (%v)", err)
+	}
+	failed := []string{}
+	for _, url := range w.urls {
+		if err := w.send(url, ev, body); err != nil {
+			w.writeDeadLetter(deadLetter{URL: url, Event: ev, Error: err.Error(), Time: time.Now()})
+			failed = append(failed, url)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Could not deliver webhook event %d to %v", ev.ID, failed)
+	}
+	return nil
+}
+
+// send posts body to url, retrying with exponential backoff.
+func (w *webhookExporter) send(url string, ev event, body []byte) error {
+	var err error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(w.backoff * time.Duration(1<<uint(attempt-1)))
+		}
+		if err = w.post(url, ev, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (w *webhookExporter) post(url string, ev event, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Could not create webhook request for %v: (%v)", url, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(webhookEventHeader, ev.Type)
+	req.Header.Add(webhookDeliveryHeader, strconv.FormatUint(ev.ID, 10))
+	if w.secret != "" {
+		req.Header.Add(webhookSignatureHeader, signWebhook(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Could not get response from %v: (%v)", url, err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook %v returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (w *webhookExporter) writeDeadLetter(d deadLetter) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	json.NewEncoder(w.deadLetter).Encode(d)
+}
+
+// signWebhook returns the signature of body, e.g. sha256=6f1ed002ab55..., so
+// that receivers can check that a delivery comes from the aggregator.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
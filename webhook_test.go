@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver records deliveries and fails the first failures of them.
+type webhookReceiver struct {
+	mutex      sync.Mutex
+	failures   int
+	attempts   int
+	deliveries []*http.Request
+	bodies     [][]byte
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.attempts++
+	if rc.attempts <= rc.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rc.deliveries = append(rc.deliveries, r)
+	rc.bodies = append(rc.bodies, body)
+}
+
+func newTestWebhookExporter(t *testing.T, receiver http.Handler, retries int, deadLetter *bytes.Buffer) *webhookExporter {
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	w, err := newWebhookExporter([]string{server.URL}, "secret", retries, time.Millisecond, time.Second, deadLetter, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWebhookExporterDeliversEvents(t *testing.T) {
+	assert := assert.New(t)
+	receiver := &webhookReceiver{}
+	w := newTestWebhookExporter(t, receiver, 0, &bytes.Buffer{})
+	refdata := about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}, Doc: doc{Name: "uw-service-refdata"}}
+	updated := about{Service: refdata.Service, Doc: doc{Name: "uw-service-refdata", Description: "refdata"}}
+
+	assert.Equal("published", outcome(w.handle(refdata)))
+	assert.Equal("nothing published", outcome(w.handle(refdata)))
+	assert.Equal("published", outcome(w.handle(updated)))
+	assert.Equal("published", outcome(w.remove(refdata.Service)))
+	assert.Equal("nothing published", outcome(w.remove(refdata.Service)))
+
+	assert.Len(receiver.deliveries, 3)
+	for i, expected := range []event{{ID: 1, Type: eventAdded, About: refdata}, {ID: 2, Type: eventUpdated, About: updated}, {ID: 3, Type: eventRemoved, About: updated}} {
+		var ev event
+		assert.NoError(json.Unmarshal(receiver.bodies[i], &ev))
+		assert.Equal(expected.ID, ev.ID)
+		assert.Equal(expected.Type, ev.Type)
+		assert.Equal(expected.About.Doc, ev.About.Doc)
+		assert.Equal(expected.Type, receiver.deliveries[i].Header.Get(webhookEventHeader))
+		assert.Equal(signWebhook("secret", receiver.bodies[i]), receiver.deliveries[i].Header.Get(webhookSignatureHeader))
+	}
+}
+
+func TestWebhookExporterRetries(t *testing.T) {
+	assert := assert.New(t)
+	receiver := &webhookReceiver{failures: 2}
+	var deadLetter bytes.Buffer
+	w := newTestWebhookExporter(t, receiver, 2, &deadLetter)
+
+	assert.Equal("published", outcome(w.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})))
+	assert.Equal(3, receiver.attempts)
+	assert.Len(receiver.deliveries, 1)
+	assert.Empty(deadLetter.String())
+}
+
+func TestWebhookExporterDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+	receiver := &webhookReceiver{failures: 10}
+	var deadLetters bytes.Buffer
+	w := newTestWebhookExporter(t, receiver, 1, &deadLetters)
+
+	_, err := w.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})
+	assert.Error(err)
+	assert.True(strings.HasPrefix(err.Error(), "Could not deliver webhook event 1 to "))
+	assert.Equal(2, receiver.attempts)
+
+	var d deadLetter
+	assert.NoError(json.Unmarshal(deadLetters.Bytes(), &d))
+	assert.Equal(eventAdded, d.Event.Type)
+	assert.Equal("uw-service-refdata", d.Event.About.Service.Name)
+	assert.Contains(d.Error, "returned status 503")
+}
+
+func TestWebhookExporterTimeout(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer server.Close()
+	defer close(release)
+	var deadLetters bytes.Buffer
+	w, _ := newWebhookExporter([]string{server.URL}, "", 0, time.Millisecond, 50*time.Millisecond, &deadLetters, http.DefaultClient)
+
+	_, err := w.handle(about{Service: service{Name: "uw-service-refdata", Namespace: "billing"}})
+	assert.Error(err)
+	assert.Contains(deadLetters.String(), "context deadline exceeded")
+}
+
+func TestSignWebhook(t *testing.T) {
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", signWebhook("key", []byte("The quick brown fox jumps over the lazy dog")))
+}
+
+func TestNewWebhookExporterErrors(t *testing.T) {
+	assert := assert.New(t)
+	_, err := newWebhookExporter(nil, "", 0, time.Second, time.Second, &bytes.Buffer{}, http.DefaultClient)
+	assert.EqualError(err, "At least one webhook url is required")
+	_, err = newWebhookExporter([]string{"http://example.com"}, "", -1, time.Second, time.Second, &bytes.Buffer{}, http.DefaultClient)
+	assert.EqualError(err, "Webhook retries can't be negative")
+}